@@ -0,0 +1,67 @@
+package main
+
+import (
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/calibrate"
+	"analysis-model/pkg/power"
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/go-osstat/memory"
+)
+
+func main() {
+	var cfg calibrate.Config
+	out := flag.String("out", "calibration.csv", "training CSV to write")
+	header := flag.Bool("header", true, "write a header row naming the columns")
+	source := flag.String("power", power.SourceAuto, "power source: auto, rapl or turbostat")
+	powercap := flag.String("powercap", power.DefaultPowercapRoot, "powercap sysfs root for the RAPL source")
+	workers := flag.Int("workers", runtime.NumCPU(), "goroutines used by the CPU burn phases")
+	memShare := flag.Float64("mem", 0.4, "largest memory phase as a share of total memory")
+	dir := flag.String("dir", os.TempDir(), "directory for the file I/O phases")
+	features := flag.String("features", strings.Join(analysis.ExtraFeatures, ","), "extra features to record after cpu and mem, comma separated")
+	flag.DurationVar(&cfg.Settle, "settle", 3*time.Second, "time each phase runs before recording")
+	flag.DurationVar(&cfg.Duration, "duration", 20*time.Second, "recording time of each phase")
+	flag.DurationVar(&cfg.Interval, "interval", time.Second, "time between recorded rows")
+	flag.Parse()
+
+	log.SetFlags(log.Lshortfile)
+	if *features != "" {
+		cfg.Features = strings.Split(*features, ",")
+	}
+
+	src, err := power.Open(*source, *powercap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+
+	runner, err := calibrate.NewRunner(cfg, src)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mem, err := memory.Get()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := runner.Run(calibrate.DefaultPhases(*workers, mem.Total, *memShare, *dir)); err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := runner.WriteCSV(f, *header); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("calibrate: wrote", runner.Rows(), "rows to", *out)
+}
@@ -1,12 +1,32 @@
 package main
 
 import (
+	"analysis-model/pkg/power"
 	"analysis-model/pkg/rest"
+	"flag"
 	"log"
+	"time"
 )
 
 func main() {
+	var cfg rest.Config
+	flag.StringVar(&cfg.Addr, "addr", ":50500", "listen address")
+	flag.StringVar(&cfg.DataDir, "data", "./data", "directory for the measurement history and power models")
+	flag.StringVar(&cfg.PowerSource, "power", power.SourceAuto, "power source: auto, rapl, turbostat or model")
+	flag.StringVar(&cfg.PowercapRoot, "powercap", power.DefaultPowercapRoot, "powercap sysfs root for the RAPL source")
+	flag.BoolVar(&cfg.Online, "online", false, "refine the active power model from measured samples")
+	flag.Float64Var(&cfg.Forgetting, "forgetting", power.DefaultForgetting, "forgetting factor of the online model updates")
+	flag.DurationVar(&cfg.Checkpoint, "checkpoint", 5*time.Minute, "interval between checkpoints of the online model")
+	flag.IntVar(&cfg.Drift.Window, "drift-window", power.DefaultDriftThresholds.Window, "samples in the rolling model drift window")
+	flag.Float64Var(&cfg.Drift.Warning, "drift-warning", power.DefaultDriftThresholds.Warning, "rolling MAPE in percent at which model drift is a warning")
+	flag.Float64Var(&cfg.Drift.Stale, "drift-stale", power.DefaultDriftThresholds.Stale, "rolling MAPE in percent at which the model is stale")
+	flag.StringVar(&cfg.Profile, "profile", "", "device profile to run under, detected from /proc/cpuinfo when empty")
+	flag.DurationVar(&cfg.Baseline, "baseline", 0, "capture the idle baseline of the device profile for this long before serving")
+	flag.StringVar(&cfg.Attribution, "attribution", "", "split energy between overlapping sessions, charging idle and untracked energy proportional, equal or none")
+	flag.DurationVar(&cfg.AttributionInterval, "attribution-interval", time.Second, "interval between energy attribution readings")
+	flag.Parse()
+
 	log.SetFlags(log.Lshortfile)
 	log.Println("50500 Server Start")
-	rest.Run()
+	rest.Run(cfg)
 }
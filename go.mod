@@ -7,5 +7,5 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mackerelio/go-osstat v0.2.0
 	github.com/sajari/regression v1.0.1
-	gonum.org/v1/gonum v0.9.3 // indirect
+	gonum.org/v1/gonum v0.9.3
 )
@@ -2,11 +2,8 @@ package analysis
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"runtime"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/mackerelio/go-osstat/memory"
@@ -16,35 +13,147 @@ type Analysis struct {
 	Cpu    float64 `json:"cpu"`
 	Memory float64 `json:"memory"`
 	Energy float64 `json:"energy"`
+
+	// CpuDetail is the average breakdown of Cpu by state and core.
+	CpuDetail *CPUDetail `json:"cpuDetail,omitempty"`
+
+	// Disks is the I/O of every sampled block device over the whole
+	// measurement.
+	Disks []DiskUsage `json:"disks,omitempty"`
+
+	// Samples is how many samples the figures were computed from.
+	Samples int `json:"samples,omitempty"`
+
+	// Model is the ID of the power model Energy was predicted with, and
+	// EnergyInterval its prediction interval when the model has one.
+	Model          string    `json:"model,omitempty"`
+	EnergyInterval *Interval `json:"energyInterval,omitempty"`
+
+	// Profile is the device profile the figures were computed under.
+	Profile string `json:"profile,omitempty"`
+
+	// Stats describes the spread of the samples behind the averages.
+	Stats *Stats `json:"stats,omitempty"`
+
+	// Integrated is the energy of the whole measurement in joules, next to
+	// the single wattage in Energy.
+	Integrated *EnergyReport `json:"integratedEnergy,omitempty"`
+
+	// Breakdown splits Integrated into the device's idle and the dynamic
+	// energy when its profile has an idle baseline.
+	Breakdown *EnergyBreakdown `json:"energyBreakdown,omitempty"`
+
+	// Attributed is the part of the machine's energy charged to the
+	// session when attribution splits it between overlapping sessions.
+	Attributed *Attribution `json:"attributed,omitempty"`
+
+	// Target is the share of the figures above that belongs to the
+	// measured processes or cgroup, when the session names one.
+	Target *Analysis `json:"target,omitempty"`
 }
 
+// Sample is a single reading taken by a measurement sampler.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	Cpu    float64   `json:"cpu"`
+	Memory float64   `json:"memory"`
+	Power  float64   `json:"power,omitempty"`
+
+	// Measured is set when Power holds a reading of the power source. A
+	// failed read leaves it unset and Power zero.
+	Measured bool `json:"measured,omitempty"`
+
+	// CpuDetail breaks Cpu down by state and core.
+	CpuDetail *CPUDetail `json:"cpuDetail,omitempty"`
+
+	// PowerDetail holds the extra columns of sources such as turbostat,
+	// keyed by column name.
+	PowerDetail map[string]float64 `json:"powerDetail,omitempty"`
+
+	// Features holds the extra model features collected by FeatureSampler,
+	// keyed by feature name.
+	Features map[string]float64 `json:"features,omitempty"`
+
+	// Disks is the I/O of every sampled block device since the previous
+	// sample.
+	Disks []DiskUsage `json:"disks,omitempty"`
+
+	// Predicted is the model's power for the sample, with its prediction
+	// interval when the model has one.
+	Predicted         float64   `json:"predicted"`
+	PredictedInterval *Interval `json:"predictedInterval,omitempty"`
+
+	Target *TargetUsage `json:"target,omitempty"`
+}
+
+// cpuMeasure returns the idle and total ticks of the aggregate cpu line of
+// /proc/stat. Time waiting for I/O counts as idle and guest time, which the
+// kernel already counts as user time, is not counted twice.
 func cpuMeasure() (idle, total uint64) {
-	contents, err := ioutil.ReadFile("/proc/stat")
+	all, _, err := ReadCPUStats()
 	if err != nil {
+		log.Println(err)
 		return
 	}
-	lines := strings.Split(string(contents), "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if fields[0] == "cpu" {
-			numFields := len(fields)
-			for i := 1; i < numFields; i++ {
-				val, err := strconv.ParseUint(fields[i], 10, 64)
-				if err != nil {
-					fmt.Println("Error: ", i, fields[i], err)
-				}
-				total += val
-				if i == 4 {
-					idle = val
-				}
-			}
-			return
+	return all.LegacyTicks()
+}
+
+// CPUTicks returns the cumulative idle and total ticks of the aggregate cpu
+// line in /proc/stat as the legacy Cpu figure counts them. Callers compute
+// utilisation from two readings.
+func CPUTicks() (idle, total uint64) {
+	return cpuMeasure()
+}
+
+// CPUSampler reports CPU utilisation between consecutive Sample calls, so a
+// sampler driven by a ticker needs no sleep of its own.
+type CPUSampler struct {
+	all    CPUStat
+	cores  []CPUStat
+	quota  *cgroupQuota
+	detail CPUDetail
+}
+
+// NewCPUSampler takes the reading the first Sample is measured against.
+func NewCPUSampler() *CPUSampler {
+	all, cores, err := ReadCPUStats()
+	if err != nil {
+		log.Println(err)
+	}
+	return &CPUSampler{all: all, cores: cores, quota: findCgroupQuota()}
+}
+
+// Sample returns the CPU usage in percent since the previous call, in the
+// legacy definition the power models are trained on. Detail has the
+// stricter busy share.
+func (c *CPUSampler) Sample() float64 {
+	all, cores, err := ReadCPUStats()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	states, busy := CPUStateDelta(c.all, all)
+	c.detail = CPUDetail{Busy: busy, States: states}
+	if len(cores) == len(c.cores) {
+		c.detail.Cores = make([]float64, len(cores))
+		for i := range cores {
+			_, c.detail.Cores[i] = CPUStateDelta(c.cores[i], cores[i])
 		}
 	}
-	return
+	if c.quota != nil {
+		c.detail.Quota = c.quota.cpus
+		c.detail.QuotaUtilization = c.quota.sample()
+	}
+	usage := LegacyUsage(c.all, all)
+	c.all, c.cores = all, cores
+	return usage
 }
 
-var ssdCpu, csdCpu = 0, 0
+// Detail returns the breakdown of the window measured by the last Sample
+// call.
+func (c *CPUSampler) Detail() CPUDetail {
+	return c.detail
+}
 
 func GetCPU(cpuChan chan float64) {
 	idle0, total0 := cpuMeasure()
@@ -54,11 +163,17 @@ func GetCPU(cpuChan chan float64) {
 	totalTicks := float64(total1 - total0)
 	cpuUsage := 100 * (totalTicks - idleTicks) / totalTicks
 
-	cpuBenefit := csdCpu / ssdCpu
 	log.Printf("CPU usage is %f%% [busy: %f, total: %f]\n", cpuUsage, totalTicks-idleTicks, totalTicks)
 	cpuChan <- cpuUsage
+}
 
-	fmt.Println(cpuBenefit)
+// MemUsage returns the used share of physical memory in percent.
+func MemUsage() (float64, error) {
+	mem, err := memory.Get()
+	if err != nil {
+		return 0, err
+	}
+	return 100 * (float64(mem.Used) / float64(mem.Total)), nil
 }
 
 func GetMem(memChan chan float64) {
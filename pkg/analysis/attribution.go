@@ -0,0 +1,113 @@
+package analysis
+
+import "fmt"
+
+// Remainder policies decide who is charged the energy no session's
+// processes caused: the idle draw of the device and the dynamic energy of
+// untracked processes.
+const (
+	// RemainderProportional charges the remainder in proportion to the
+	// CPU shares of the sessions, equally when none used any CPU.
+	RemainderProportional = "proportional"
+	// RemainderEqual charges every active session the same part of it.
+	RemainderEqual = "equal"
+	// RemainderNone charges no session and reports it as unattributed.
+	RemainderNone = "none"
+)
+
+// ValidRemainder rejects an unknown remainder policy.
+func ValidRemainder(policy string) error {
+	switch policy {
+	case RemainderProportional, RemainderEqual, RemainderNone:
+		return nil
+	}
+	return fmt.Errorf("unknown remainder policy %q", policy)
+}
+
+// Attribution is the part of the machine's energy charged to one session
+// while other sessions ran beside it. Direct follows the CPU time of the
+// session's processes and Remainder is its part of the idle and untracked
+// energy under Policy. CpuShare is the time-weighted share of the
+// machine's CPU the processes used, in percent.
+type Attribution struct {
+	Policy    string  `json:"policy"`
+	Joules    float64 `json:"joules"`
+	Direct    float64 `json:"direct"`
+	Remainder float64 `json:"remainder"`
+	CpuShare  float64 `json:"cpuShare"`
+	Duration  float64 `json:"duration"`
+}
+
+// Add charges the attribution of one interval of seconds in which the
+// session's processes used share percent of the CPU.
+func (a *Attribution) Add(direct, remainder, share, seconds float64) {
+	if total := a.Duration + seconds; total > 0 {
+		a.CpuShare = (a.CpuShare*a.Duration + share*seconds) / total
+	}
+	a.Duration += seconds
+	a.Direct += direct
+	a.Remainder += remainder
+	a.Joules = a.Direct + a.Remainder
+}
+
+// Attribute splits the joules of one interval between sessions whose
+// processes used shares percent of the machine's CPU while the whole
+// machine was busy cpu percent, idleJoules of the energy being the idle
+// draw of the device. The dynamic rest is split by CPU time between the
+// sessions and the untracked processes; the idle energy and the untracked
+// part are then charged under policy. The direct and remainder charges
+// add up to joules less the returned unattributed energy, which is zero
+// unless the policy is RemainderNone or there are no sessions.
+func Attribute(joules, idleJoules, cpu float64, shares []float64, policy string) (direct, remainder []float64, unattributed float64) {
+	direct = make([]float64, len(shares))
+	remainder = make([]float64, len(shares))
+
+	idle := idleJoules
+	if idle > joules {
+		idle = joules
+	}
+	if idle < 0 {
+		idle = 0
+	}
+	dynamic := joules - idle
+
+	tracked := 0.0
+	for _, share := range shares {
+		if share > 0 {
+			tracked += share
+		}
+	}
+	// The session samplers and the machine sampler do not read the
+	// counters at the same instant, so the shares can add up to a little
+	// more than the machine's usage.
+	busy := cpu
+	if tracked > busy {
+		busy = tracked
+	}
+
+	untracked := dynamic
+	if busy > 0 {
+		for i, share := range shares {
+			if share > 0 {
+				direct[i] = dynamic * share / busy
+			}
+		}
+		untracked = dynamic * (busy - tracked) / busy
+	}
+	rest := idle + untracked
+
+	if len(shares) == 0 || policy == RemainderNone {
+		return direct, remainder, rest
+	}
+	for i, share := range shares {
+		switch {
+		case policy == RemainderProportional && tracked > 0:
+			if share > 0 {
+				remainder[i] = rest * share / tracked
+			}
+		default:
+			remainder[i] = rest / float64(len(shares))
+		}
+	}
+	return direct, remainder, 0
+}
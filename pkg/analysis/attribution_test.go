@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAttribute(t *testing.T) {
+	tests := []struct {
+		name              string
+		joules, idle, cpu float64
+		shares            []float64
+		policy            string
+		direct, remainder []float64
+		unattributed      float64
+	}{
+		{
+			name:   "proportional",
+			joules: 100, idle: 20, cpu: 50, shares: []float64{30, 10}, policy: RemainderProportional,
+			// 80 J dynamic over 50% busy; 16 J untracked plus 20 J idle.
+			direct: []float64{48, 16}, remainder: []float64{27, 9},
+		},
+		{
+			name:   "equal",
+			joules: 100, idle: 20, cpu: 50, shares: []float64{30, 10}, policy: RemainderEqual,
+			direct: []float64{48, 16}, remainder: []float64{18, 18},
+		},
+		{
+			name:   "none",
+			joules: 100, idle: 20, cpu: 50, shares: []float64{30, 10}, policy: RemainderNone,
+			direct: []float64{48, 16}, remainder: []float64{0, 0}, unattributed: 36,
+		},
+		{
+			name:   "zero shares proportional",
+			joules: 100, idle: 20, cpu: 50, shares: []float64{0, 0}, policy: RemainderProportional,
+			direct: []float64{0, 0}, remainder: []float64{50, 50},
+		},
+		{
+			name:   "zero shares none",
+			joules: 100, idle: 20, cpu: 50, shares: []float64{0, 0}, policy: RemainderNone,
+			direct: []float64{0, 0}, remainder: []float64{0, 0}, unattributed: 100,
+		},
+		{
+			name:   "zero idle",
+			joules: 60, idle: 0, cpu: 50, shares: []float64{25}, policy: RemainderProportional,
+			direct: []float64{30}, remainder: []float64{30},
+		},
+		{
+			name:   "shares above machine cpu",
+			joules: 100, idle: 30, cpu: 50, shares: []float64{40, 30}, policy: RemainderProportional,
+			// The shares stand for all of the dynamic energy.
+			direct: []float64{40, 30}, remainder: []float64{30 * 4.0 / 7, 30 * 3.0 / 7},
+		},
+		{
+			name:   "idle above measured",
+			joules: 10, idle: 25, cpu: 40, shares: []float64{20}, policy: RemainderEqual,
+			direct: []float64{0}, remainder: []float64{10},
+		},
+		{
+			name:   "no sessions",
+			joules: 100, idle: 20, cpu: 50, policy: RemainderProportional,
+			direct: []float64{}, remainder: []float64{}, unattributed: 100,
+		},
+	}
+	const eps = 1e-9
+	for _, tt := range tests {
+		direct, remainder, unattributed := Attribute(tt.joules, tt.idle, tt.cpu, tt.shares, tt.policy)
+
+		total := unattributed
+		for i := range tt.shares {
+			total += direct[i] + remainder[i]
+		}
+		if math.Abs(total-tt.joules) > eps {
+			t.Errorf("%s: charges add up to %.6f J, want the %.6f J measured", tt.name, total, tt.joules)
+		}
+		for i := range tt.shares {
+			if math.Abs(direct[i]-tt.direct[i]) > eps || math.Abs(remainder[i]-tt.remainder[i]) > eps {
+				t.Errorf("%s: session %d charged %.4f + %.4f J, want %.4f + %.4f J", tt.name, i, direct[i], remainder[i], tt.direct[i], tt.remainder[i])
+			}
+		}
+		if math.Abs(unattributed-tt.unattributed) > eps {
+			t.Errorf("%s: unattributed = %.4f J, want %.4f J", tt.name, unattributed, tt.unattributed)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CPUStat holds the cumulative ticks of one cpu line of /proc/stat by
+// state. The kernel counts guest time in User and guest_nice time in Nice
+// as well, so Total leaves both guest columns out.
+type CPUStat struct {
+	Name      string
+	User      uint64
+	Nice      uint64
+	System    uint64
+	Idle      uint64
+	IOWait    uint64
+	IRQ       uint64
+	SoftIRQ   uint64
+	Steal     uint64
+	Guest     uint64
+	GuestNice uint64
+}
+
+// Total returns the ticks spent in every state.
+func (c CPUStat) Total() uint64 {
+	return c.User + c.Nice + c.System + c.Idle + c.IOWait + c.IRQ + c.SoftIRQ + c.Steal
+}
+
+// IdleTicks returns the ticks the CPU ran nothing, waiting for I/O or not.
+func (c CPUStat) IdleTicks() uint64 {
+	return c.Idle + c.IOWait
+}
+
+// LegacyTicks returns the idle and total ticks behind the Cpu figure the
+// server has always reported, which every power model is trained on: idle
+// leaves iowait out and total adds up every column, guest time included.
+func (c CPUStat) LegacyTicks() (idle, total uint64) {
+	return c.Idle, c.Total() + c.Guest + c.GuestNice
+}
+
+// LegacyUsage returns the Cpu figure between two readings of the same cpu
+// line, in percent. CPUStateDelta has the stricter busy share.
+func LegacyUsage(before, after CPUStat) float64 {
+	idle0, total0 := before.LegacyTicks()
+	idle1, total1 := after.LegacyTicks()
+	if total1 <= total0 || idle1 < idle0 {
+		return 0
+	}
+	total := float64(total1 - total0)
+	return 100 * (total - float64(idle1-idle0)) / total
+}
+
+// ParseProcStat parses the cpu lines of the /proc/stat format: the
+// aggregate line and one line per core in order. Columns older kernels do
+// not have are left zero.
+func ParseProcStat(r io.Reader) (all CPUStat, cores []CPUStat, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		st := CPUStat{Name: fields[0]}
+		columns := []*uint64{
+			&st.User, &st.Nice, &st.System, &st.Idle, &st.IOWait,
+			&st.IRQ, &st.SoftIRQ, &st.Steal, &st.Guest, &st.GuestNice,
+		}
+		for i, v := range fields[1:] {
+			if i == len(columns) {
+				break
+			}
+			*columns[i], err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return all, nil, fmt.Errorf("stat %s: %v", st.Name, err)
+			}
+		}
+		if st.Name == "cpu" {
+			all = st
+		} else {
+			cores = append(cores, st)
+		}
+	}
+	return all, cores, scanner.Err()
+}
+
+// ReadCPUStats reads the cpu lines of /proc/stat.
+func ReadCPUStats() (all CPUStat, cores []CPUStat, err error) {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return all, nil, err
+	}
+	defer f.Close()
+	return ParseProcStat(f)
+}
+
+// CPUStates is the share of CPU time spent in each state, in percent of
+// all CPUs. Guest is already part of User and Nice, so the other states
+// add up to 100.
+type CPUStates struct {
+	User    float64 `json:"user"`
+	Nice    float64 `json:"nice"`
+	System  float64 `json:"system"`
+	Idle    float64 `json:"idle"`
+	IOWait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+	Steal   float64 `json:"steal"`
+	Guest   float64 `json:"guest"`
+}
+
+// CPUStateDelta returns the state shares between two readings of the same
+// cpu line and the busy share, which leaves out idle and iowait.
+func CPUStateDelta(before, after CPUStat) (states CPUStates, busy float64) {
+	delta := func(a, b uint64) float64 {
+		if b < a {
+			return 0
+		}
+		return float64(b - a)
+	}
+	total := delta(before.Total(), after.Total())
+	if total == 0 {
+		return states, 0
+	}
+	share := func(a, b uint64) float64 {
+		return 100 * delta(a, b) / total
+	}
+	states = CPUStates{
+		User:    share(before.User, after.User),
+		Nice:    share(before.Nice, after.Nice),
+		System:  share(before.System, after.System),
+		Idle:    share(before.Idle, after.Idle),
+		IOWait:  share(before.IOWait, after.IOWait),
+		IRQ:     share(before.IRQ, after.IRQ),
+		SoftIRQ: share(before.SoftIRQ, after.SoftIRQ),
+		Steal:   share(before.Steal, after.Steal),
+		Guest:   share(before.Guest+before.GuestNice, after.Guest+after.GuestNice),
+	}
+	busy = 100 * (total - delta(before.IdleTicks(), after.IdleTicks())) / total
+	return states, busy
+}
+
+// CPUDetail breaks the CPU usage of a sample window down by state and by
+// core. Busy is the share of CPU time spent running, which unlike the
+// legacy Cpu figure counts iowait as idle and guest time once; the cores
+// use the same definition. Inside a cgroup with a CPU quota, Quota is the
+// number of CPUs the quota allows and QuotaUtilization the cgroup's usage
+// as a percentage of it.
+type CPUDetail struct {
+	Busy             float64   `json:"busy"`
+	States           CPUStates `json:"states"`
+	Cores            []float64 `json:"cores,omitempty"`
+	Quota            float64   `json:"quota,omitempty"`
+	QuotaUtilization float64   `json:"quotaUtilization,omitempty"`
+}
+
+// AverageCPUDetail averages the details of consecutive sample windows.
+// Cores are averaged over the windows that reported them.
+func AverageCPUDetail(details []CPUDetail) *CPUDetail {
+	if len(details) == 0 {
+		return nil
+	}
+	avg := &CPUDetail{}
+	var coreCounts []int
+	quotaCount := 0
+	for _, d := range details {
+		avg.Busy += d.Busy
+		s := &avg.States
+		s.User += d.States.User
+		s.Nice += d.States.Nice
+		s.System += d.States.System
+		s.Idle += d.States.Idle
+		s.IOWait += d.States.IOWait
+		s.IRQ += d.States.IRQ
+		s.SoftIRQ += d.States.SoftIRQ
+		s.Steal += d.States.Steal
+		s.Guest += d.States.Guest
+		for i, v := range d.Cores {
+			if i == len(avg.Cores) {
+				avg.Cores = append(avg.Cores, 0)
+				coreCounts = append(coreCounts, 0)
+			}
+			avg.Cores[i] += v
+			coreCounts[i]++
+		}
+		if d.Quota > 0 {
+			avg.Quota = d.Quota
+			avg.QuotaUtilization += d.QuotaUtilization
+			quotaCount++
+		}
+	}
+	n := float64(len(details))
+	avg.Busy /= n
+	s := &avg.States
+	s.User /= n
+	s.Nice /= n
+	s.System /= n
+	s.Idle /= n
+	s.IOWait /= n
+	s.IRQ /= n
+	s.SoftIRQ /= n
+	s.Steal /= n
+	s.Guest /= n
+	for i := range avg.Cores {
+		avg.Cores[i] /= float64(coreCounts[i])
+	}
+	if quotaCount > 0 {
+		avg.QuotaUtilization /= float64(quotaCount)
+	}
+	return avg
+}
+
+// cgroupQuota follows the CPU usage of the cgroup v2 that limits the
+// server with the tightest cpu.max quota.
+type cgroupQuota struct {
+	path  string
+	cpus  float64
+	usage uint64
+	at    time.Time
+}
+
+// ownCgroup returns the cgroup v2 path of the server from /proc/self/cgroup.
+func ownCgroup() (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(procRoot, "self/cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", filepath.Join(procRoot, "self/cgroup"))
+}
+
+// readCPUMax returns the CPUs a cpu.max file allows, 0 for no quota.
+func readCPUMax(path string) float64 {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0
+	}
+	return quota / period
+}
+
+// findCgroupQuota walks from the server's cgroup up to the root and picks
+// the ancestor with the smallest quota. It returns nil when none has one.
+func findCgroupQuota() *cgroupQuota {
+	own, err := ownCgroup()
+	if err != nil {
+		return nil
+	}
+	var q *cgroupQuota
+	dir := filepath.Join(cgroupRoot, own)
+	for strings.HasPrefix(dir, cgroupRoot) {
+		if cpus := readCPUMax(filepath.Join(dir, "cpu.max")); cpus > 0 && (q == nil || cpus < q.cpus) {
+			q = &cgroupQuota{path: dir, cpus: cpus}
+		}
+		if dir == cgroupRoot {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	if q == nil {
+		return nil
+	}
+	usage, err := readCgroupFile(filepath.Join(q.path, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return nil
+	}
+	q.usage, q.at = usage, time.Now()
+	return q
+}
+
+// sample returns the cgroup's CPU usage since the previous call as a
+// percentage of its quota.
+func (q *cgroupQuota) sample() float64 {
+	usage, err := readCgroupFile(filepath.Join(q.path, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return 0
+	}
+	now := time.Now()
+	elapsed := now.Sub(q.at).Seconds()
+	used := 0.0
+	if usage > q.usage {
+		used = float64(usage-q.usage) / 1e6
+	}
+	q.usage, q.at = usage, now
+	if elapsed <= 0 {
+		return 0
+	}
+	return 100 * used / (elapsed * q.cpus)
+}
@@ -0,0 +1,144 @@
+package analysis
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Between the two procstat fixtures both CPUs ran 1000 ticks: cpu0 was
+// half busy with 100 ticks of iowait and 100 of guest time, cpu1 ran
+// 200 ticks with 100 of iowait.
+var fixtureProcStat = filepath.Join("testdata", "procstat")
+
+func readFixtureStat(t *testing.T, name string) (CPUStat, []CPUStat) {
+	t.Helper()
+	f, err := os.Open(filepath.Join(fixtureProcStat, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	all, cores, err := ParseProcStat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all, cores
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
+
+func TestParseProcStat(t *testing.T) {
+	all, cores := readFixtureStat(t, "stat.0")
+	want := CPUStat{Name: "cpu", User: 1000, Nice: 100, System: 500, Idle: 8000, IOWait: 200, IRQ: 50, SoftIRQ: 50, Steal: 100, Guest: 300}
+	if all != want {
+		t.Errorf("cpu = %+v, want %+v", all, want)
+	}
+	if len(cores) != 2 || cores[1].Name != "cpu1" || cores[1].Idle != 4100 {
+		t.Errorf("cores = %+v, want cpu0 and cpu1", cores)
+	}
+
+	// Old kernels have no steal and guest columns.
+	all, cores = readFixtureStat(t, "stat.old")
+	want = CPUStat{Name: "cpu", User: 100, System: 50, Idle: 800, IOWait: 20, IRQ: 1, SoftIRQ: 2}
+	if all != want || len(cores) != 1 {
+		t.Errorf("old format = %+v with %d cores, want %+v with 1", all, len(cores), want)
+	}
+}
+
+func TestCPUStateDelta(t *testing.T) {
+	before, beforeCores := readFixtureStat(t, "stat.0")
+	after, afterCores := readFixtureStat(t, "stat.1")
+
+	states, busy := CPUStateDelta(before, after)
+	want := CPUStates{User: 20, System: 10, Idle: 55, IOWait: 10, IRQ: 2.5, SoftIRQ: 2.5, Guest: 5}
+	if states != want {
+		t.Errorf("states = %+v, want %+v", states, want)
+	}
+	if !near(busy, 35) {
+		t.Errorf("busy = %.3f%%, want 35%%", busy)
+	}
+	// The legacy figure counts iowait as busy and guest time twice.
+	if legacy := LegacyUsage(before, after); !near(legacy, 100*1000.0/2100) {
+		t.Errorf("legacy usage = %.3f%%, want %.3f%%", legacy, 100*1000.0/2100)
+	}
+
+	for i, want := range []float64{50, 20} {
+		if _, busy := CPUStateDelta(beforeCores[i], afterCores[i]); !near(busy, want) {
+			t.Errorf("cpu%d busy = %.3f%%, want %.0f%%", i, busy, want)
+		}
+	}
+
+	// Counters that went backwards, as after a CPU hotplug, give nothing.
+	if states, busy := CPUStateDelta(after, before); busy != 0 || states != (CPUStates{}) {
+		t.Errorf("backwards delta = %+v, %.3f", states, busy)
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	dir := t.TempDir()
+	proc := procRoot
+	procRoot = dir
+	defer func() { procRoot = proc }()
+
+	copyFile(t, filepath.Join(fixtureProcStat, "stat.0"), filepath.Join(dir, "stat"))
+	c := NewCPUSampler()
+	copyFile(t, filepath.Join(fixtureProcStat, "stat.1"), filepath.Join(dir, "stat"))
+
+	if usage := c.Sample(); !near(usage, 100*1000.0/2100) {
+		t.Errorf("Sample = %.3f%%, want the legacy %.3f%%", usage, 100*1000.0/2100)
+	}
+	detail := c.Detail()
+	if !near(detail.Busy, 35) || len(detail.Cores) != 2 || !near(detail.Cores[0], 50) || !near(detail.Cores[1], 20) {
+		t.Errorf("detail = %+v, want 35%% busy over cores of 50%% and 20%%", detail)
+	}
+
+	avg := AverageCPUDetail([]CPUDetail{detail, {Busy: 15, Cores: []float64{10}}})
+	if !near(avg.Busy, 25) || !near(avg.Cores[0], 30) || !near(avg.Cores[1], 20) {
+		t.Errorf("average = %+v, want 25%% busy over cores of 30%% and 20%%", avg)
+	}
+}
+
+func TestReadCPUMax(t *testing.T) {
+	root := filepath.Join("testdata", "cgroupquota", "sys")
+	tests := []struct {
+		path string
+		want float64
+	}{
+		{"cpu.max", 0},
+		{"kubepods/pod1/cpu.max", 1.5},
+		{"kubepods/pod1/ctr/cpu.max", 4},
+		{"missing/cpu.max", 0},
+	}
+	for _, tt := range tests {
+		if got := readCPUMax(filepath.Join(root, tt.path)); got != tt.want {
+			t.Errorf("readCPUMax(%s) = %g, want %g", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFindCgroupQuota(t *testing.T) {
+	proc, cgroup := procRoot, cgroupRoot
+	procRoot = filepath.Join("testdata", "cgroupquota", "proc")
+	cgroupRoot = filepath.Join("testdata", "cgroupquota", "sys")
+	defer func() { procRoot, cgroupRoot = proc, cgroup }()
+
+	// The pod allows 1.5 CPUs, tighter than its container's 4.
+	q := findCgroupQuota()
+	if q == nil {
+		t.Fatal("no quota found")
+	}
+	if want := filepath.Join(cgroupRoot, "kubepods", "pod1"); q.path != want || q.cpus != 1.5 {
+		t.Fatalf("quota = %g CPUs at %s, want 1.5 at %s", q.cpus, q.path, want)
+	}
+
+	// 1.5 CPU seconds over 2 s is half of the quota.
+	q.usage -= 1500000
+	q.at = time.Now().Add(-2 * time.Second)
+	if got := q.sample(); math.Abs(got-50) > 0.5 {
+		t.Errorf("quota utilisation = %.2f%%, want 50%%", got)
+	}
+}
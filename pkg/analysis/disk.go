@@ -0,0 +1,250 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiskStat holds the cumulative counters of one /proc/diskstats line.
+// Ticks are milliseconds: the time reads and writes spent queued and in
+// flight, and the time the device had any I/O in flight.
+type DiskStat struct {
+	Name         string
+	ReadIOs      uint64
+	ReadSectors  uint64
+	ReadTicks    uint64
+	WriteIOs     uint64
+	WriteSectors uint64
+	WriteTicks   uint64
+	IOTicks      uint64
+}
+
+// ParseDiskStats parses the /proc/diskstats format. Lines with too few
+// columns are skipped.
+func ParseDiskStats(r io.Reader) ([]DiskStat, error) {
+	var stats []DiskStat
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		st := DiskStat{Name: fields[2]}
+		counters := []*uint64{
+			&st.ReadIOs, nil, &st.ReadSectors, &st.ReadTicks,
+			&st.WriteIOs, nil, &st.WriteSectors, &st.WriteTicks,
+			nil, &st.IOTicks,
+		}
+		for i, counter := range counters {
+			if counter == nil {
+				continue
+			}
+			v, err := strconv.ParseUint(fields[3+i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("diskstats %s: %v", st.Name, err)
+			}
+			*counter = v
+		}
+		stats = append(stats, st)
+	}
+	return stats, scanner.Err()
+}
+
+// ReadDiskStats reads a diskstats file such as /proc/diskstats.
+func ReadDiskStats(path string) ([]DiskStat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseDiskStats(f)
+}
+
+// DiskUsage is the I/O of one block device over a sample window of
+// Seconds. Latencies are the mean milliseconds per completed request and
+// Utilization the percentage of the window the device was busy.
+type DiskUsage struct {
+	Device       string  `json:"device"`
+	ReadBytes    uint64  `json:"readBytes"`
+	WriteBytes   uint64  `json:"writeBytes"`
+	ReadIOs      uint64  `json:"readIOs"`
+	WriteIOs     uint64  `json:"writeIOs"`
+	ReadIOPS     float64 `json:"readIOPS"`
+	WriteIOPS    float64 `json:"writeIOPS"`
+	ReadLatency  float64 `json:"readLatency"`
+	WriteLatency float64 `json:"writeLatency"`
+	Utilization  float64 `json:"utilization"`
+	Seconds      float64 `json:"seconds"`
+}
+
+// DiskDelta returns the usage between two readings of the same device
+// taken seconds apart. Counters that went backwards count as zero.
+func DiskDelta(before, after DiskStat, seconds float64) DiskUsage {
+	delta := func(a, b uint64) uint64 {
+		if b < a {
+			return 0
+		}
+		return b - a
+	}
+	u := DiskUsage{
+		Device:     after.Name,
+		ReadBytes:  delta(before.ReadSectors, after.ReadSectors) * diskSectorSize,
+		WriteBytes: delta(before.WriteSectors, after.WriteSectors) * diskSectorSize,
+		ReadIOs:    delta(before.ReadIOs, after.ReadIOs),
+		WriteIOs:   delta(before.WriteIOs, after.WriteIOs),
+		Seconds:    seconds,
+	}
+	u.setRates(float64(delta(before.ReadTicks, after.ReadTicks)), float64(delta(before.WriteTicks, after.WriteTicks)), float64(delta(before.IOTicks, after.IOTicks)))
+	return u
+}
+
+// setRates fills the per-second and per-request figures from the request
+// counts, Seconds and the milliseconds spent on reads, writes and busy.
+func (u *DiskUsage) setRates(readMs, writeMs, busyMs float64) {
+	u.ReadIOPS, u.WriteIOPS, u.ReadLatency, u.WriteLatency, u.Utilization = 0, 0, 0, 0, 0
+	if u.Seconds > 0 {
+		u.ReadIOPS = float64(u.ReadIOs) / u.Seconds
+		u.WriteIOPS = float64(u.WriteIOs) / u.Seconds
+		u.Utilization = 100 * busyMs / (1000 * u.Seconds)
+		if u.Utilization > 100 {
+			u.Utilization = 100
+		}
+	}
+	if u.ReadIOs > 0 {
+		u.ReadLatency = readMs / float64(u.ReadIOs)
+	}
+	if u.WriteIOs > 0 {
+		u.WriteLatency = writeMs / float64(u.WriteIOs)
+	}
+}
+
+// MergeDiskUsage adds up the usage of every device over consecutive
+// windows into one entry per device, sorted by name.
+func MergeDiskUsage(usages []DiskUsage) []DiskUsage {
+	type totals struct {
+		usage                   DiskUsage
+		readMs, writeMs, busyMs float64
+	}
+	byDevice := make(map[string]*totals)
+	for _, u := range usages {
+		t, ok := byDevice[u.Device]
+		if !ok {
+			t = &totals{usage: DiskUsage{Device: u.Device}}
+			byDevice[u.Device] = t
+		}
+		t.usage.ReadBytes += u.ReadBytes
+		t.usage.WriteBytes += u.WriteBytes
+		t.usage.ReadIOs += u.ReadIOs
+		t.usage.WriteIOs += u.WriteIOs
+		t.usage.Seconds += u.Seconds
+		t.readMs += u.ReadLatency * float64(u.ReadIOs)
+		t.writeMs += u.WriteLatency * float64(u.WriteIOs)
+		t.busyMs += u.Utilization / 100 * u.Seconds * 1000
+	}
+
+	merged := make([]DiskUsage, 0, len(byDevice))
+	for _, t := range byDevice {
+		t.usage.setRates(t.readMs, t.writeMs, t.busyMs)
+		merged = append(merged, t.usage)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Device < merged[j].Device })
+	return merged
+}
+
+// ValidDiskPatterns rejects malformed device patterns.
+func ValidDiskPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("disk pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// DiskSampler collects the usage of block devices between consecutive
+// Sample calls.
+type DiskSampler struct {
+	path      string
+	blockRoot string
+	patterns  []string
+	last      map[string]DiskStat
+	at        time.Time
+}
+
+// NewDiskSampler samples the devices of /proc/diskstats whose names match
+// one of the shell patterns, such as "nvme0n1" or "nvme*n*". Without
+// patterns it samples every whole disk that is not a virtual device such
+// as a loop, zram or device-mapper device.
+func NewDiskSampler(patterns []string) *DiskSampler {
+	return NewDiskSamplerAt(filepath.Join(procRoot, "diskstats"), blockRoot(), patterns)
+}
+
+// NewDiskSamplerAt is NewDiskSampler reading the diskstats file at path
+// and telling partitions and virtual devices apart by the sysfs
+// class/block directory at blockRoot.
+func NewDiskSamplerAt(path, blockRoot string, patterns []string) *DiskSampler {
+	ds := &DiskSampler{path: path, blockRoot: blockRoot, patterns: patterns}
+	ds.last, ds.at = ds.read(), time.Now()
+	return ds
+}
+
+func (ds *DiskSampler) selected(name string) bool {
+	if len(ds.patterns) == 0 {
+		return !isPartition(ds.blockRoot, name) && !isVirtual(ds.blockRoot, name)
+	}
+	for _, pattern := range ds.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isVirtual reports whether a block device has no hardware behind it.
+func isVirtual(blockRoot, name string) bool {
+	path, err := filepath.EvalSymlinks(filepath.Join(blockRoot, name))
+	return err == nil && strings.Contains(filepath.ToSlash(path), "/devices/virtual/")
+}
+
+func (ds *DiskSampler) read() map[string]DiskStat {
+	stats, err := ReadDiskStats(ds.path)
+	if err != nil {
+		return nil
+	}
+	selected := make(map[string]DiskStat)
+	for _, st := range stats {
+		if ds.selected(st.Name) {
+			selected[st.Name] = st
+		}
+	}
+	return selected
+}
+
+// Sample returns the usage of every selected device since the previous
+// call, sorted by device name. Devices that appeared since then are left
+// out until the next call.
+func (ds *DiskSampler) Sample() []DiskUsage {
+	now := time.Now()
+	current := ds.read()
+	seconds := now.Sub(ds.at).Seconds()
+
+	var usages []DiskUsage
+	for name, st := range current {
+		before, ok := ds.last[name]
+		if !ok {
+			continue
+		}
+		usages = append(usages, DiskDelta(before, st, seconds))
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Device < usages[j].Device })
+
+	ds.last, ds.at = current, now
+	return usages
+}
@@ -0,0 +1,145 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The fixture tree holds a whole NVMe disk with one partition, a virtio
+// disk and a loop and a device-mapper device under devices/virtual.
+var (
+	fixtureDiskStats = filepath.Join("testdata", "diskstats")
+	fixtureBlockRoot = filepath.Join("testdata", "sys", "class", "block")
+)
+
+// fixtureSampler returns a sampler over a copy of the first diskstats
+// fixture. The copy is then replaced by the second fixture, taken two
+// seconds later.
+func fixtureSampler(t *testing.T, patterns []string) *DiskSampler {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "diskstats")
+	copyFile(t, filepath.Join(fixtureDiskStats, "diskstats.0"), path)
+	ds := NewDiskSamplerAt(path, fixtureBlockRoot, patterns)
+	copyFile(t, filepath.Join(fixtureDiskStats, "diskstats.1"), path)
+	ds.at = time.Now().Add(-2 * time.Second)
+	return ds
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+	contents, err := ioutil.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(to, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func devices(usages []DiskUsage) []string {
+	names := make([]string, 0, len(usages))
+	for _, u := range usages {
+		names = append(names, u.Device)
+	}
+	return names
+}
+
+func TestParseDiskStats(t *testing.T) {
+	stats, err := ReadDiskStats(filepath.Join(fixtureDiskStats, "diskstats.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The short sda1 line of the old partition format is skipped.
+	if len(stats) != 5 {
+		t.Fatalf("parsed %d devices, want 5", len(stats))
+	}
+	want := DiskStat{
+		Name:         "nvme0n1",
+		ReadIOs:      10000,
+		ReadSectors:  800000,
+		ReadTicks:    5000,
+		WriteIOs:     4000,
+		WriteSectors: 320000,
+		WriteTicks:   8000,
+		IOTicks:      6000,
+	}
+	if stats[3] != want {
+		t.Errorf("nvme0n1 = %+v, want %+v", stats[3], want)
+	}
+}
+
+func TestDiskSamplerSelection(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{nil, []string{"nvme0n1", "vda"}},
+		{[]string{"nvme*"}, []string{"nvme0n1", "nvme0n1p1"}},
+		{[]string{"dm-*", "loop0"}, []string{"dm-0", "loop0"}},
+		{[]string{"sd*"}, []string{}},
+	}
+	for _, tt := range tests {
+		got := devices(fixtureSampler(t, tt.patterns).Sample())
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("patterns %q sampled %q, want %q", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestDiskSamplerRates(t *testing.T) {
+	usages := fixtureSampler(t, []string{"nvme0n1", "vda"}).Sample()
+	if len(usages) != 2 {
+		t.Fatalf("sampled %q, want nvme0n1 and vda", devices(usages))
+	}
+
+	u := usages[0]
+	if u.ReadBytes != 409600*diskSectorSize || u.WriteBytes != 102400*diskSectorSize {
+		t.Errorf("bytes = %d read, %d written, want %d and %d", u.ReadBytes, u.WriteBytes, 409600*diskSectorSize, 102400*diskSectorSize)
+	}
+	if u.ReadIOs != 2000 || u.WriteIOs != 500 {
+		t.Errorf("requests = %d read, %d written, want 2000 and 500", u.ReadIOs, u.WriteIOs)
+	}
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"read IOPS", u.ReadIOPS, 1000},
+		{"write IOPS", u.WriteIOPS, 250},
+		{"read latency", u.ReadLatency, 0.5},
+		{"write latency", u.WriteLatency, 4},
+		{"utilization", u.Utilization, 25},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want) > 0.01*c.want {
+			t.Errorf("%s = %.3f, want %.3f", c.name, c.got, c.want)
+		}
+	}
+
+	// An idle device still reports a window.
+	if idle := usages[1]; idle.ReadIOs != 0 || idle.Utilization != 0 || idle.Seconds == 0 {
+		t.Errorf("vda = %+v, want an idle window", idle)
+	}
+}
+
+func TestMergeDiskUsage(t *testing.T) {
+	windows := []DiskUsage{
+		{Device: "vda", ReadIOs: 100, ReadLatency: 2, Utilization: 50, Seconds: 1},
+		{Device: "nvme0n1", WriteIOs: 10, WriteLatency: 1, Utilization: 10, Seconds: 1},
+		{Device: "vda", ReadIOs: 300, ReadLatency: 1, Utilization: 100, Seconds: 1},
+	}
+	merged := MergeDiskUsage(windows)
+	if got := devices(merged); !reflect.DeepEqual(got, []string{"nvme0n1", "vda"}) {
+		t.Fatalf("merged devices %q", got)
+	}
+	vda := merged[1]
+	if vda.ReadIOs != 400 || vda.Seconds != 2 {
+		t.Errorf("vda totals = %d requests over %.0f s, want 400 over 2 s", vda.ReadIOs, vda.Seconds)
+	}
+	if math.Abs(vda.ReadLatency-1.25) > 1e-9 || math.Abs(vda.ReadIOPS-200) > 1e-9 || math.Abs(vda.Utilization-75) > 1e-9 {
+		t.Errorf("vda rates = %.3f ms, %.1f IOPS, %.1f%%, want 1.25 ms, 200 IOPS, 75%%", vda.ReadLatency, vda.ReadIOPS, vda.Utilization)
+	}
+}
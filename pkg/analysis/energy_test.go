@@ -0,0 +1,45 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakdownSumsToTotal(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds ...float64) []time.Time {
+		times := make([]time.Time, len(seconds))
+		for i, s := range seconds {
+			times[i] = start.Add(time.Duration(s * float64(time.Second)))
+		}
+		return times
+	}
+
+	tests := []struct {
+		name          string
+		times         []time.Time
+		watts         []float64
+		idleWatts     float64
+		idle, dynamic float64
+	}{
+		// 310 J over 10 s, against a 12 W baseline.
+		{"busy", at(0, 2, 4, 10), []float64{20, 40, 30, 30}, 12, 120, 190},
+		{"at the baseline", at(0, 1, 2), []float64{12, 12, 12}, 12, 24, 0},
+		{"below the baseline", at(0, 5), []float64{10, 10}, 12, 60, -10},
+		{"no baseline", at(0, 1), []float64{8, 8}, 0, 0, 8},
+		{"single reading", at(0), []float64{25}, 12, 0, 0},
+	}
+	for _, tt := range tests {
+		report := Integrate(tt.times, tt.watts)
+		b := Breakdown(report, tt.idleWatts)
+		if b.Total != report.Joules || b.IdleWatts != tt.idleWatts {
+			t.Errorf("%s: total %.3f J at %.1f W idle, want the report's %.3f J at %.1f W", tt.name, b.Total, b.IdleWatts, report.Joules, tt.idleWatts)
+		}
+		if !near(b.Idle+b.Dynamic, b.Total) {
+			t.Errorf("%s: idle %.3f J + dynamic %.3f J != total %.3f J", tt.name, b.Idle, b.Dynamic, b.Total)
+		}
+		if !near(b.Idle, tt.idle) || !near(b.Dynamic, tt.dynamic) {
+			t.Errorf("%s: idle %.3f J, dynamic %.3f J, want %.3f and %.3f", tt.name, b.Idle, b.Dynamic, tt.idle, tt.dynamic)
+		}
+	}
+}
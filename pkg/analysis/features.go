@@ -0,0 +1,211 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the features a power model can be trained on. Cpu and Memory
+// are the sample's own fields; the others are collected by FeatureSampler.
+const (
+	FeatureCPU             = "Cpu"
+	FeatureMemory          = "Memory"
+	FeatureDiskRead        = "DiskRead"
+	FeatureDiskWrite       = "DiskWrite"
+	FeatureCPUFreq         = "CpuFreq"
+	FeatureContextSwitches = "ContextSwitches"
+	FeaturePkgTemp         = "PkgTemp"
+)
+
+// ExtraFeatures lists the features FeatureSampler can collect, with their
+// units: disk bandwidth in MB/s, frequency in MHz, context switches per
+// second and package temperature in degrees Celsius.
+var ExtraFeatures = []string{
+	FeatureDiskRead,
+	FeatureDiskWrite,
+	FeatureCPUFreq,
+	FeatureContextSwitches,
+	FeaturePkgTemp,
+}
+
+var sysRoot = "/sys"
+
+// blockRoot is the sysfs directory with an entry for every block device
+// and partition.
+func blockRoot() string {
+	return filepath.Join(sysRoot, "class/block")
+}
+
+// diskSectorSize is the unit of the sector counters in /proc/diskstats,
+// which is 512 bytes whatever the device's real sector size.
+const diskSectorSize = 512
+
+// FeatureSampler collects the extra model features between consecutive
+// Sample calls. Counters such as disk sectors and context switches are
+// turned into rates over the time between calls.
+type FeatureSampler struct {
+	features []string
+	at       time.Time
+	sectors  [2]uint64
+	ctxt     uint64
+}
+
+// NewFeatureSampler samples the named features. Names it does not know,
+// including Cpu and Memory, are ignored.
+func NewFeatureSampler(features []string) *FeatureSampler {
+	fs := &FeatureSampler{at: time.Now()}
+	for _, name := range features {
+		for _, known := range ExtraFeatures {
+			if name == known {
+				fs.features = append(fs.features, name)
+			}
+		}
+	}
+	fs.sectors = diskSectors()
+	fs.ctxt = contextSwitches()
+	return fs
+}
+
+// Empty reports whether the sampler has nothing to collect.
+func (fs *FeatureSampler) Empty() bool {
+	return len(fs.features) == 0
+}
+
+// Sample returns the feature values since the previous call.
+func (fs *FeatureSampler) Sample() map[string]float64 {
+	if fs.Empty() {
+		return nil
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(fs.at).Seconds()
+	sectors := diskSectors()
+	ctxt := contextSwitches()
+
+	values := make(map[string]float64, len(fs.features))
+	for _, name := range fs.features {
+		switch name {
+		case FeatureDiskRead:
+			values[name] = rate(fs.sectors[0], sectors[0], elapsed) * diskSectorSize / 1e6
+		case FeatureDiskWrite:
+			values[name] = rate(fs.sectors[1], sectors[1], elapsed) * diskSectorSize / 1e6
+		case FeatureContextSwitches:
+			values[name] = rate(fs.ctxt, ctxt, elapsed)
+		case FeatureCPUFreq:
+			values[name] = cpuFreqMHz()
+		case FeaturePkgTemp:
+			values[name] = pkgTemp()
+		}
+	}
+
+	fs.at, fs.sectors, fs.ctxt = now, sectors, ctxt
+	return values
+}
+
+func rate(before, after uint64, elapsed float64) float64 {
+	if elapsed <= 0 || after < before {
+		return 0
+	}
+	return float64(after-before) / elapsed
+}
+
+// isPartition reports whether a /proc/diskstats entry is a partition, whose
+// I/O is already counted in its whole-disk entry. blockRoot is the
+// class/block directory of sysfs.
+func isPartition(blockRoot, name string) bool {
+	_, err := ioutil.ReadFile(filepath.Join(blockRoot, name, "partition"))
+	return err == nil
+}
+
+// diskSectors returns the sectors read and written by every whole disk.
+func diskSectors() (sectors [2]uint64) {
+	stats, err := ReadDiskStats(filepath.Join(procRoot, "diskstats"))
+	if err != nil {
+		return
+	}
+	for _, st := range stats {
+		if isPartition(blockRoot(), st.Name) {
+			continue
+		}
+		sectors[0] += st.ReadSectors
+		sectors[1] += st.WriteSectors
+	}
+	return
+}
+
+// contextSwitches returns the ctxt counter of /proc/stat.
+func contextSwitches() uint64 {
+	contents, err := ioutil.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "ctxt" {
+			v, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				log.Println(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+// cpuFreqMHz averages the current frequency of every CPU from cpufreq.
+func cpuFreqMHz() float64 {
+	paths, err := filepath.Glob(filepath.Join(sysRoot, "devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq"))
+	if err != nil || len(paths) == 0 {
+		return 0
+	}
+	total := 0.0
+	count := 0
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		khz, err := strconv.ParseFloat(strings.TrimSpace(string(contents)), 64)
+		if err != nil {
+			continue
+		}
+		total += khz / 1000
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// pkgTemp reads the package temperature from the thermal zones, preferring
+// the x86_pkg_temp zone and otherwise taking the hottest zone.
+func pkgTemp() float64 {
+	zones, err := filepath.Glob(filepath.Join(sysRoot, "class/thermal/thermal_zone*"))
+	if err != nil {
+		return 0
+	}
+	hottest := 0.0
+	for _, zone := range zones {
+		contents, err := ioutil.ReadFile(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.ParseFloat(strings.TrimSpace(string(contents)), 64)
+		if err != nil {
+			continue
+		}
+		kind, _ := ioutil.ReadFile(filepath.Join(zone, "type"))
+		if strings.TrimSpace(string(kind)) == "x86_pkg_temp" {
+			return milli / 1000
+		}
+		if milli/1000 > hottest {
+			hottest = milli / 1000
+		}
+	}
+	return hottest
+}
@@ -0,0 +1,296 @@
+package analysis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/go-osstat/memory"
+)
+
+// clockTicks is USER_HZ, the unit of utime and stime in /proc/<pid>/stat.
+// It is 100 on every Linux architecture we deploy to.
+const clockTicks = 100
+
+var (
+	procRoot   = "/proc"
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// Target selects the processes a measurement is attributed to. Either a PID
+// list, a process name pattern or a cgroup v2 path can be given.
+type Target struct {
+	PIDs    []int  `json:"pids,omitempty"`
+	Process string `json:"process,omitempty"`
+	Cgroup  string `json:"cgroup,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// TargetUsage is the resource usage of a Target over one sample window.
+// Cpu and Memory are percentages of the whole machine so they compare
+// directly with the system-wide figures.
+type TargetUsage struct {
+	Cpu         float64 `json:"cpu"`
+	Memory      float64 `json:"memory"`
+	MemoryBytes uint64  `json:"memoryBytes"`
+	Processes   int     `json:"processes,omitempty"`
+}
+
+// targetStat holds cumulative CPU seconds per PID, or under PID 0 for a
+// cgroup, together with the current memory footprint.
+type targetStat struct {
+	cpu    map[int]float64
+	memory uint64
+}
+
+// Validate checks that the target selects something and compiles the
+// process name pattern.
+func (t *Target) Validate() error {
+	selectors := 0
+	if len(t.PIDs) > 0 {
+		selectors++
+	}
+	if t.Process != "" {
+		selectors++
+		pattern, err := regexp.Compile(t.Process)
+		if err != nil {
+			return err
+		}
+		t.pattern = pattern
+	}
+	if t.Cgroup != "" {
+		selectors++
+		if _, err := os.Stat(filepath.Join(t.cgroupPath(), "cpu.stat")); err != nil {
+			return err
+		}
+	}
+	if selectors != 1 {
+		return errors.New("target needs exactly one of pids, process or cgroup")
+	}
+	return nil
+}
+
+func (t *Target) cgroupPath() string {
+	if strings.HasPrefix(t.Cgroup, cgroupRoot) {
+		return t.Cgroup
+	}
+	return filepath.Join(cgroupRoot, t.Cgroup)
+}
+
+func (t *Target) pids() []int {
+	if t.pattern == nil {
+		return t.PIDs
+	}
+
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if t.pattern.MatchString(strings.TrimSpace(string(comm))) {
+			pids = append(pids, pid)
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(procRoot, entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if t.pattern.Match(cmdline) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// procCPU returns utime+stime of a process in seconds.
+func procCPU(pid int) (float64, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// comm may contain spaces, so split after its closing parenthesis.
+	stat := string(contents)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	// fields[0] is state (field 3), so utime (14) and stime (15) are at 11 and 12.
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("short stat for pid %d", pid)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(utime+stime) / clockTicks, nil
+}
+
+// procRSS returns the resident set size of a process in bytes.
+func procRSS(pid int) (uint64, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	// Kernel threads have no VmRSS line.
+	return 0, scanner.Err()
+}
+
+func readCgroupFile(path, key string) (uint64, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if key == "" && len(fields) == 1 {
+			return strconv.ParseUint(fields[0], 10, 64)
+		}
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
+
+func (t *Target) read() (targetStat, error) {
+	stat := targetStat{cpu: make(map[int]float64)}
+
+	if t.Cgroup != "" {
+		path := t.cgroupPath()
+		usec, err := readCgroupFile(filepath.Join(path, "cpu.stat"), "usage_usec")
+		if err != nil {
+			return stat, err
+		}
+		stat.cpu[0] = float64(usec) / 1e6
+		stat.memory, err = readCgroupFile(filepath.Join(path, "memory.current"), "")
+		if err != nil {
+			return stat, err
+		}
+		return stat, nil
+	}
+
+	for _, pid := range t.pids() {
+		cpu, err := procCPU(pid)
+		if err != nil {
+			// The process may have exited between listing and reading.
+			continue
+		}
+		rss, err := procRSS(pid)
+		if err != nil {
+			continue
+		}
+		stat.cpu[pid] = cpu
+		stat.memory += rss
+	}
+	return stat, nil
+}
+
+// cpuDelta returns the CPU seconds the target used between two readings.
+// Processes that appeared in between are charged their whole CPU time.
+func cpuDelta(before, after targetStat) float64 {
+	delta := 0.0
+	for pid, cpu := range after.cpu {
+		prev := before.cpu[pid]
+		if cpu > prev {
+			delta += cpu - prev
+		}
+	}
+	return delta
+}
+
+// TargetSampler reports the usage of a Target between consecutive Sample
+// calls.
+type TargetSampler struct {
+	target *Target
+	cpus   int
+	last   targetStat
+	at     time.Time
+}
+
+// NewTargetSampler takes the reading the first Sample is measured against.
+func NewTargetSampler(t *Target) *TargetSampler {
+	last, err := t.read()
+	if err != nil {
+		log.Println(err)
+	}
+	return &TargetSampler{target: t, cpus: machineCPUs(), last: last, at: time.Now()}
+}
+
+// machineCPUs returns the number of CPUs in /proc/stat. Unlike
+// runtime.NumCPU it does not shrink when the server runs under taskset or
+// in a cpuset-limited container, so the target's share compares with the
+// machine-wide usage.
+func machineCPUs() int {
+	_, cores, err := ReadCPUStats()
+	if err != nil || len(cores) == 0 {
+		return runtime.NumCPU()
+	}
+	return len(cores)
+}
+
+// Sample returns the target usage since the previous call.
+func (ts *TargetSampler) Sample() TargetUsage {
+	now, err := ts.target.read()
+	if err != nil {
+		log.Println(err)
+	}
+	at := time.Now()
+	elapsed := at.Sub(ts.at).Seconds()
+
+	usage := TargetUsage{
+		MemoryBytes: now.memory,
+	}
+	if elapsed > 0 {
+		usage.Cpu = 100 * cpuDelta(ts.last, now) / (elapsed * float64(ts.cpus))
+	}
+	if ts.target.Cgroup == "" {
+		usage.Processes = len(now.cpu)
+	}
+	mem, err := memory.Get()
+	if err != nil {
+		log.Println(err)
+	} else if mem.Total > 0 {
+		usage.Memory = 100 * float64(now.memory) / float64(mem.Total)
+	}
+
+	ts.last, ts.at = now, at
+	return usage
+}
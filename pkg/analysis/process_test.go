@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// useFixtureRoots points /proc and the cgroup root at the testdata trees:
+// a four-CPU machine running postgres (pid 100, 4 s of CPU), a bash running
+// pg_dump (pid 200, 1 s) and a kernel thread (pid 300), and a db.slice
+// cgroup.
+func useFixtureRoots(t *testing.T) {
+	t.Helper()
+	proc, cgroup := procRoot, cgroupRoot
+	procRoot = filepath.Join("testdata", "proc")
+	cgroupRoot = filepath.Join("testdata", "cgroup")
+	t.Cleanup(func() { procRoot, cgroupRoot = proc, cgroup })
+}
+
+func statPIDs(stat targetStat) []int {
+	pids := make([]int, 0, len(stat.cpu))
+	for pid := range stat.cpu {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func TestTargetResolution(t *testing.T) {
+	useFixtureRoots(t)
+	tests := []struct {
+		name   string
+		target Target
+		pids   []int
+		cpu    float64
+		memory uint64
+	}{
+		{"pids", Target{PIDs: []int{100, 999}}, []int{100}, 4, 2048 * 1024},
+		{"comm pattern", Target{Process: "^postgres$"}, []int{100}, 4, 2048 * 1024},
+		{"cmdline pattern", Target{Process: "postgres"}, []int{100, 200}, 5, 3072 * 1024},
+		{"comm with spaces", Target{Process: "kworker"}, []int{300}, 0.1, 0},
+		{"cgroup", Target{Cgroup: "db.slice"}, []int{0}, 5, 8 << 20},
+	}
+	for _, tt := range tests {
+		target := tt.target
+		if err := target.Validate(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		stat, err := target.read()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := statPIDs(stat); !reflect.DeepEqual(got, tt.pids) {
+			t.Errorf("%s: resolved pids %v, want %v", tt.name, got, tt.pids)
+		}
+		if cpu := cpuDelta(targetStat{}, stat); math.Abs(cpu-tt.cpu) > 1e-9 {
+			t.Errorf("%s: %.2f CPU seconds, want %.2f", tt.name, cpu, tt.cpu)
+		}
+		if stat.memory != tt.memory {
+			t.Errorf("%s: %d bytes, want %d", tt.name, stat.memory, tt.memory)
+		}
+	}
+}
+
+func TestTargetValidate(t *testing.T) {
+	useFixtureRoots(t)
+	for _, target := range []Target{
+		{},
+		{PIDs: []int{1}, Process: "x"},
+		{Process: "("},
+		{Cgroup: "missing.slice"},
+	} {
+		if err := target.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", target)
+		}
+	}
+}
+
+func TestTargetSamplerMachineShare(t *testing.T) {
+	useFixtureRoots(t)
+	target := &Target{PIDs: []int{100}}
+	ts := NewTargetSampler(target)
+	if ts.cpus != 4 {
+		t.Fatalf("sampler counts %d CPUs, want the 4 of /proc/stat", ts.cpus)
+	}
+
+	// postgres used 2 of its 4 CPU seconds over the last 2 s, half a CPU
+	// of four.
+	ts.last.cpu[100] -= 2
+	ts.at = time.Now().Add(-2 * time.Second)
+	usage := ts.Sample()
+	if math.Abs(usage.Cpu-25) > 0.5 {
+		t.Errorf("Cpu = %.2f%%, want 25%% of the machine", usage.Cpu)
+	}
+	if usage.Processes != 1 || usage.MemoryBytes != 2048*1024 {
+		t.Errorf("usage = %+v, want one process of 2 MiB", usage)
+	}
+}
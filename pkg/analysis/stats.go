@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes the distribution of one sampled series. CILow and
+// CIHigh bound the 95% confidence interval of the mean.
+type Summary struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	StdDev float64 `json:"stddev"`
+	CILow  float64 `json:"ciLow"`
+	CIHigh float64 `json:"ciHigh"`
+}
+
+// Stats holds the distribution of every series of a measurement. Power is
+// only present when the node measured its power draw.
+type Stats struct {
+	Cpu            Summary  `json:"cpu"`
+	Memory         Summary  `json:"memory"`
+	Power          *Summary `json:"power,omitempty"`
+	PredictedPower Summary  `json:"predictedPower"`
+}
+
+// tTable holds the two-sided 95% critical values of Student's t
+// distribution for 1 to 30 degrees of freedom.
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// TCritical95 returns the two-sided 95% critical value of Student's t
+// distribution, falling back to the normal value above 30 degrees of
+// freedom.
+func TCritical95(df int) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(tTable) {
+		return tTable[df-1]
+	}
+	return 1.96
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted values using
+// linear interpolation between the closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// Summarize computes the distribution of a series.
+func Summarize(values []float64) Summary {
+	n := len(values)
+	if n == 0 {
+		return Summary{}
+	}
+
+	sorted := make([]float64, n)
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	total := 0.0
+	for _, v := range sorted {
+		total += v
+	}
+	mean := total / float64(n)
+
+	sum := Summary{
+		Min:    sorted[0],
+		Max:    sorted[n-1],
+		Mean:   mean,
+		P50:    Percentile(sorted, 50),
+		P90:    Percentile(sorted, 90),
+		P99:    Percentile(sorted, 99),
+		CILow:  mean,
+		CIHigh: mean,
+	}
+	if n > 1 {
+		squares := 0.0
+		for _, v := range sorted {
+			squares += (v - mean) * (v - mean)
+		}
+		sum.StdDev = math.Sqrt(squares / float64(n-1))
+		margin := TCritical95(n-1) * sum.StdDev / math.Sqrt(float64(n))
+		sum.CILow = mean - margin
+		sum.CIHigh = mean + margin
+	}
+	return sum
+}
@@ -0,0 +1,159 @@
+// Package calibrate runs synthetic load phases while recording utilisation
+// and measured power, producing training data for the power model.
+package calibrate
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+)
+
+// Phase is one load level. Load runs until stop is closed.
+type Phase struct {
+	Name string
+	Load func(stop <-chan struct{})
+}
+
+// Config controls a calibration run.
+type Config struct {
+	// Settle is the time each phase runs before recording starts, so that
+	// power and temperature follow the new load.
+	Settle time.Duration
+
+	// Duration is the recording time of each phase.
+	Duration time.Duration
+
+	// Interval is the time between two recorded rows.
+	Interval time.Duration
+
+	// Features lists the extra features recorded after cpu and mem.
+	Features []string
+}
+
+// Runner runs phases against one power source.
+type Runner struct {
+	config Config
+	source power.PowerSource
+	rows   [][]float64
+}
+
+// NewRunner returns a runner reading power from source, which has to be
+// a measured one.
+func NewRunner(config Config, source power.PowerSource) (*Runner, error) {
+	if !source.Measured() {
+		return nil, errors.New("calibration needs a measured power source, got " + source.Name())
+	}
+	for _, name := range config.Features {
+		if !extraFeature(name) {
+			return nil, errors.New("unknown feature " + name)
+		}
+	}
+	if config.Interval <= 0 {
+		return nil, errors.New("calibration interval must be positive")
+	}
+	return &Runner{config: config, source: source}, nil
+}
+
+func extraFeature(name string) bool {
+	for _, known := range analysis.ExtraFeatures {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs every phase in order and records its rows.
+func (r *Runner) Run(phases []Phase) error {
+	for i, phase := range phases {
+		log.Printf("calibrate: phase %d/%d %s", i+1, len(phases), phase.Name)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			phase.Load(stop)
+		}()
+
+		time.Sleep(r.config.Settle)
+		n, err := r.record()
+
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			return err
+		}
+		log.Printf("calibrate: phase %s recorded %d rows", phase.Name, n)
+	}
+	return nil
+}
+
+// record samples for one phase duration.
+func (r *Runner) record() (int, error) {
+	cpu := analysis.NewCPUSampler()
+	features := analysis.NewFeatureSampler(r.config.Features)
+	// Read once so the first row covers one interval of this phase only.
+	if _, err := r.source.Read(); err != nil && err != power.ErrNotMeasured {
+		log.Println(err)
+	}
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	deadline := time.After(r.config.Duration)
+	count := 0
+	for {
+		select {
+		case <-deadline:
+			return count, nil
+		case <-ticker.C:
+			watts, err := r.source.Read()
+			if err != nil {
+				log.Println("calibrate: skipping row:", err)
+				continue
+			}
+			mem, err := analysis.MemUsage()
+			if err != nil {
+				return count, err
+			}
+			row := []float64{watts, cpu.Sample(), mem}
+			values := features.Sample()
+			for _, name := range r.config.Features {
+				row = append(row, values[name])
+			}
+			r.rows = append(r.rows, row)
+			count++
+		}
+	}
+}
+
+// Rows returns the number of rows recorded so far.
+func (r *Runner) Rows() int {
+	return len(r.rows)
+}
+
+// WriteCSV writes the recorded rows in the [power, cpu, mem, features...]
+// layout Regression consumes. With header set, the first row names the
+// columns as the model store expects.
+func (r *Runner) WriteCSV(w io.Writer, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		names := []string{power.FeaturePower, power.FeatureCPU, power.FeatureMemory}
+		cw.Write(append(names, r.config.Features...))
+	}
+	for _, row := range r.rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = strconv.FormatFloat(v, 'f', 4, 64)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
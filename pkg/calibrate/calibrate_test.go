@@ -0,0 +1,56 @@
+package calibrate
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"math/rand"
+	"testing"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+)
+
+// Known relationship of the recorded rows: P = a*cpu + b*mem + c.
+const (
+	trueA = 0.8
+	trueB = 0.25
+	trueC = 12.0
+)
+
+func recordedRunner(features []string) *Runner {
+	rng := rand.New(rand.NewSource(3))
+	r := &Runner{config: Config{Features: features, Interval: 1}}
+	for i := 0; i < 200; i++ {
+		cpu := 100 * rng.Float64()
+		mem := 10 + 70*rng.Float64()
+		row := []float64{trueA*cpu + trueB*mem + trueC, cpu, mem}
+		for range features {
+			row = append(row, 1000+rng.Float64())
+		}
+		r.rows = append(r.rows, row)
+	}
+	return r
+}
+
+func TestWriteCSVFeedsRegression(t *testing.T) {
+	for _, header := range []bool{true, false} {
+		r := recordedRunner([]string{analysis.FeatureCPUFreq})
+		var buf bytes.Buffer
+		if err := r.WriteCSV(&buf, header); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := r.Rows(); header && len(records) != want+1 || !header && len(records) != want {
+			t.Fatalf("header %v: wrote %d records for %d rows", header, len(records), want)
+		}
+
+		a, b, c := power.NewFormula().Regression(records)
+		if math.Abs(a-trueA) > 0.001 || math.Abs(b-trueB) > 0.001 || math.Abs(c-trueC) > 0.05 {
+			t.Errorf("header %v: Regression = %.4f*cpu + %.4f*mem + %.4f, want %.4f*cpu + %.4f*mem + %.4f", header, a, b, c, trueA, trueB, trueC)
+		}
+	}
+}
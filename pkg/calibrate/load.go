@@ -0,0 +1,158 @@
+package calibrate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// dutyPeriod is the period over which CPU burners alternate between busy
+// and idle to reach their utilisation level.
+const dutyPeriod = 10 * time.Millisecond
+
+// pageSize is the stride used to touch allocated memory.
+const pageSize = 4096
+
+// Idle is a phase without load, giving the baseline rows.
+func Idle() Phase {
+	return Phase{
+		Name: "idle",
+		Load: func(stop <-chan struct{}) { <-stop },
+	}
+}
+
+// CPUBurn keeps workers goroutines busy for level percent of the time.
+func CPUBurn(level float64, workers int) Phase {
+	busy := time.Duration(float64(dutyPeriod) * level / 100)
+	return Phase{
+		Name: fmt.Sprintf("cpu-%.0f%%x%d", level, workers),
+		Load: func(stop <-chan struct{}) {
+			parallel(workers, func() {
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					start := time.Now()
+					for time.Since(start) < busy {
+					}
+					if idle := dutyPeriod - busy; idle > 0 {
+						time.Sleep(idle)
+					}
+				}
+			})
+		},
+	}
+}
+
+// Memory allocates size bytes and streams over them until stopped, so the
+// footprint stays resident and the memory bus is busy.
+func Memory(size uint64) Phase {
+	return Phase{
+		Name: fmt.Sprintf("mem-%dMiB", size>>20),
+		Load: func(stop <-chan struct{}) {
+			buf := make([]byte, size)
+			for {
+				for i := 0; i < len(buf); i += pageSize {
+					buf[i]++
+				}
+				select {
+				case <-stop:
+					buf = nil
+					debug.FreeOSMemory()
+					return
+				default:
+				}
+			}
+		},
+	}
+}
+
+// FileIO writes size bytes to a file in dir, syncs it and reads it back
+// until stopped.
+func FileIO(dir string, size int64) Phase {
+	return Phase{
+		Name: fmt.Sprintf("io-%dMiB", size>>20),
+		Load: func(stop <-chan struct{}) {
+			f, err := ioutil.TempFile(dir, "calibrate-")
+			if err != nil {
+				log.Println(err)
+				<-stop
+				return
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			chunk := make([]byte, 1<<20)
+			for i := range chunk {
+				chunk[i] = byte(i)
+			}
+			for {
+				if err := fileRound(f, chunk, size); err != nil {
+					log.Println(err)
+					<-stop
+					return
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		},
+	}
+}
+
+func fileRound(f *os.File, chunk []byte, size int64) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for written := int64(0); written < size; written += int64(len(chunk)) {
+		if _, err := f.Write(chunk); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(ioutil.Discard, f)
+	return err
+}
+
+func parallel(workers int, fn func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	wg.Wait()
+}
+
+// DefaultPhases returns the built-in schedule: an idle baseline, CPU burn
+// at graded levels, memory at graded shares of memTotal up to memShare and
+// file I/O in dir.
+func DefaultPhases(workers int, memTotal uint64, memShare float64, dir string) []Phase {
+	phases := []Phase{Idle()}
+	for _, level := range []float64{10, 25, 50, 75, 100} {
+		phases = append(phases, CPUBurn(level, workers))
+	}
+	for _, step := range []float64{0.25, 0.5, 0.75, 1} {
+		phases = append(phases, Memory(uint64(float64(memTotal)*memShare*step)))
+	}
+	for _, size := range []int64{64 << 20, 256 << 20} {
+		phases = append(phases, FileIO(dir, size))
+	}
+	return phases
+}
@@ -0,0 +1,178 @@
+package power
+
+import (
+	"fmt"
+	"math"
+)
+
+// Drift statuses, from a model that matches the measured power to one that
+// should be retrained.
+const (
+	DriftOK      = "ok"
+	DriftWarning = "warning"
+	DriftStale   = "stale"
+)
+
+// DriftThresholds configures a DriftTracker. Warning and Stale are rolling
+// MAPE levels in percent.
+type DriftThresholds struct {
+	Window     int     `json:"window"`
+	MinSamples int     `json:"minSamples"`
+	Warning    float64 `json:"warning"`
+	Stale      float64 `json:"stale"`
+}
+
+// DefaultDriftThresholds are used for zero fields of DriftThresholds.
+var DefaultDriftThresholds = DriftThresholds{
+	Window:     300,
+	MinSamples: 10,
+	Warning:    10,
+	Stale:      25,
+}
+
+func (t DriftThresholds) withDefaults() DriftThresholds {
+	if t.Window <= 0 {
+		t.Window = DefaultDriftThresholds.Window
+	}
+	if t.MinSamples <= 0 {
+		t.MinSamples = DefaultDriftThresholds.MinSamples
+	}
+	if t.MinSamples > t.Window {
+		t.MinSamples = t.Window
+	}
+	if t.Warning <= 0 {
+		t.Warning = DefaultDriftThresholds.Warning
+	}
+	if t.Stale <= 0 {
+		t.Stale = DefaultDriftThresholds.Stale
+	}
+	return t
+}
+
+// Validate rejects thresholds under which the statuses do not escalate,
+// after zero fields take their defaults.
+func (t DriftThresholds) Validate() error {
+	t = t.withDefaults()
+	if t.Warning >= t.Stale {
+		return fmt.Errorf("drift warning threshold %g%% must be below the stale threshold %g%%", t.Warning, t.Stale)
+	}
+	return nil
+}
+
+// DriftReport is the rolling comparison of predicted against measured
+// power. Bias is the mean of measured minus predicted watts.
+type DriftReport struct {
+	Status     string          `json:"status"`
+	Model      string          `json:"model,omitempty"`
+	Samples    int             `json:"samples"`
+	Bias       float64         `json:"bias"`
+	MAE        float64         `json:"mae"`
+	RMSE       float64         `json:"rmse"`
+	MAPE       float64         `json:"mape"`
+	Thresholds DriftThresholds `json:"thresholds"`
+}
+
+type driftPoint struct {
+	err float64
+	pct float64
+	has bool
+}
+
+// DriftTracker keeps rolling error statistics over the last Window
+// samples of one model.
+type DriftTracker struct {
+	thresholds DriftThresholds
+	points     []driftPoint
+	next       int
+	count      int
+	status     string
+
+	sumErr, sumAbs, sumSq, sumPct float64
+	pctCount                      int
+}
+
+// NewDriftTracker returns an empty tracker.
+func NewDriftTracker(thresholds DriftThresholds) *DriftTracker {
+	thresholds = thresholds.withDefaults()
+	return &DriftTracker{
+		thresholds: thresholds,
+		points:     make([]driftPoint, thresholds.Window),
+		status:     DriftOK,
+	}
+}
+
+// Observe adds one sample and returns the drift status, reporting whether
+// it changed.
+func (d *DriftTracker) Observe(predicted, measured float64) (status string, changed bool) {
+	if d.count == len(d.points) {
+		d.remove(d.points[d.next])
+	} else {
+		d.count++
+	}
+	p := driftPoint{err: measured - predicted}
+	if measured != 0 {
+		p.pct = 100 * math.Abs(p.err/measured)
+		p.has = true
+	}
+	d.points[d.next] = p
+	d.next = (d.next + 1) % len(d.points)
+	d.sumErr += p.err
+	d.sumAbs += math.Abs(p.err)
+	d.sumSq += p.err * p.err
+	if p.has {
+		d.sumPct += p.pct
+		d.pctCount++
+	}
+
+	status = d.classify()
+	changed = status != d.status
+	d.status = status
+	return status, changed
+}
+
+func (d *DriftTracker) remove(p driftPoint) {
+	d.sumErr -= p.err
+	d.sumAbs -= math.Abs(p.err)
+	d.sumSq -= p.err * p.err
+	if p.has {
+		d.sumPct -= p.pct
+		d.pctCount--
+	}
+}
+
+func (d *DriftTracker) mape() float64 {
+	if d.pctCount == 0 {
+		return 0
+	}
+	return d.sumPct / float64(d.pctCount)
+}
+
+func (d *DriftTracker) classify() string {
+	if d.count < d.thresholds.MinSamples {
+		return DriftOK
+	}
+	switch mape := d.mape(); {
+	case mape >= d.thresholds.Stale:
+		return DriftStale
+	case mape >= d.thresholds.Warning:
+		return DriftWarning
+	}
+	return DriftOK
+}
+
+// Report returns the current rolling statistics.
+func (d *DriftTracker) Report() DriftReport {
+	r := DriftReport{
+		Status:     d.status,
+		Samples:    d.count,
+		Thresholds: d.thresholds,
+	}
+	if d.count > 0 {
+		n := float64(d.count)
+		r.Bias = d.sumErr / n
+		r.MAE = d.sumAbs / n
+		r.RMSE = math.Sqrt(math.Max(d.sumSq, 0) / n)
+		r.MAPE = d.mape()
+	}
+	return r
+}
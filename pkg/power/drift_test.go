@@ -0,0 +1,61 @@
+package power
+
+import "testing"
+
+func TestDriftTrackerTransitions(t *testing.T) {
+	d := NewDriftTracker(DriftThresholds{Window: 4, MinSamples: 2, Warning: 10, Stale: 25})
+	steps := []struct {
+		predicted float64
+		status    string
+		changed   bool
+		mape      float64
+	}{
+		{100, DriftOK, false, 0},         // below MinSamples
+		{80, DriftWarning, true, 10},     // 0, 20
+		{60, DriftWarning, false, 20},    // 0, 20, 40
+		{50, DriftStale, true, 27.5},     // 0, 20, 40, 50: the window is full
+		{100, DriftStale, false, 27.5},   // the first 0 drops out
+		{100, DriftWarning, true, 22.5},  // 40, 50, 0, 0
+		{100, DriftWarning, false, 12.5}, // 50, 0, 0, 0
+		{100, DriftOK, true, 0},          // only exact predictions left
+		{100, DriftOK, false, 0},         // the ring wraps a second time
+	}
+	for i, step := range steps {
+		status, changed := d.Observe(step.predicted, 100)
+		if status != step.status || changed != step.changed {
+			t.Fatalf("step %d: status %s changed %v, want %s changed %v", i, status, changed, step.status, step.changed)
+		}
+		if r := d.Report(); !near(r.MAPE, step.mape, 1e-9) || r.Status != status {
+			t.Fatalf("step %d: report %+v, want MAPE %.1f%% and status %s", i, r, step.mape, status)
+		}
+	}
+
+	r := d.Report()
+	if r.Samples != 4 || !near(r.Bias, 0, 1e-9) || !near(r.MAE, 0, 1e-9) || !near(r.RMSE, 0, 1e-6) {
+		t.Errorf("after wrapping: %+v, want 4 samples with no error", r)
+	}
+
+	// A zero reading has no percentage error and leaves MAPE alone.
+	d.Observe(10, 0)
+	if r := d.Report(); r.MAPE != 0 || !near(r.Bias, -2.5, 1e-9) {
+		t.Errorf("after a zero reading: %+v, want MAPE 0 and bias -2.5 W", r)
+	}
+}
+
+func TestDriftThresholdsValidate(t *testing.T) {
+	tests := []struct {
+		thresholds DriftThresholds
+		ok         bool
+	}{
+		{DriftThresholds{}, true},
+		{DriftThresholds{Warning: 5, Stale: 50}, true},
+		{DriftThresholds{Warning: 25, Stale: 25}, false},
+		{DriftThresholds{Warning: 40, Stale: 30}, false},
+		{DriftThresholds{Warning: 30}, false}, // above the default stale level
+	}
+	for _, tt := range tests {
+		if err := tt.thresholds.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.thresholds, err, tt.ok)
+		}
+	}
+}
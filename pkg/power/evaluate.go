@@ -0,0 +1,260 @@
+package power
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// Defaults for EvalOptions.
+const (
+	DefaultFolds   = 5
+	DefaultHoldout = 0.2
+)
+
+// evalSeed fixes the shuffle of the records so that evaluating the same
+// dataset twice gives the same report.
+const evalSeed = 1
+
+// EvalOptions configures the evaluation run after training.
+type EvalOptions struct {
+	// Folds is the number of cross-validation folds. It is capped at the
+	// number of records, which makes it leave-one-out.
+	Folds int `json:"folds,omitempty"`
+
+	// Holdout is the share of the records kept out of the holdout fit.
+	Holdout float64 `json:"holdout,omitempty"`
+}
+
+func (o EvalOptions) withDefaults() EvalOptions {
+	if o.Folds <= 0 {
+		o.Folds = DefaultFolds
+	}
+	if o.Holdout <= 0 || o.Holdout >= 1 {
+		o.Holdout = DefaultHoldout
+	}
+	return o
+}
+
+// ErrorMetrics describes how well predictions match observed power. MAPE
+// is a percentage and skips records with zero observed power.
+type ErrorMetrics struct {
+	R2         float64 `json:"r2"`
+	AdjustedR2 float64 `json:"adjustedR2"`
+	RMSE       float64 `json:"rmse"`
+	MAE        float64 `json:"mae"`
+	MAPE       float64 `json:"mape"`
+	N          int     `json:"n"`
+}
+
+// Residual is the out-of-fold prediction for one training record.
+type Residual struct {
+	Record    int     `json:"record"`
+	Fold      int     `json:"fold"`
+	Observed  float64 `json:"observed"`
+	Predicted float64 `json:"predicted"`
+	Residual  float64 `json:"residual"`
+}
+
+// Evaluation is the report stored with a trained model. Training holds the
+// in-sample fit, CrossValidation the pooled out-of-fold predictions of the
+// k-fold run and Holdout the fit on a held-back share of the records.
+type Evaluation struct {
+	Folds           int            `json:"folds"`
+	HoldoutShare    float64        `json:"holdoutShare"`
+	Training        ErrorMetrics   `json:"training"`
+	CrossValidation *ErrorMetrics  `json:"crossValidation,omitempty"`
+	FoldMetrics     []ErrorMetrics `json:"foldMetrics,omitempty"`
+	Holdout         *ErrorMetrics  `json:"holdout,omitempty"`
+	Residuals       []Residual     `json:"residuals,omitempty"`
+
+	// Outliers lists the rows a robust fit found far off the model.
+	Outliers []Outlier `json:"outliers,omitempty"`
+}
+
+// Evaluate scores m, trained on records, with k-fold cross-validation and
+// a holdout split, refitting with the method of train. Folds whose training
+// part cannot be fitted, for instance because a column is constant in it,
+// are left out. m can be any PowerModel, such as a Model or an Ensemble.
+func Evaluate(m PowerModel, records [][]string, features []string, train TrainOptions) (*Evaluation, error) {
+	opts := train.EvalOptions.withDefaults()
+	observed, inputs, err := parseRecords(records, features)
+	if err != nil {
+		return nil, err
+	}
+	n := len(observed)
+	if n < 2 {
+		return nil, errors.New("evaluation needs at least 2 records")
+	}
+	if opts.Folds > n {
+		opts.Folds = n
+	}
+
+	e := &Evaluation{Folds: opts.Folds, HoldoutShare: opts.Holdout}
+	predicted := make([]float64, n)
+	for i := range inputs {
+		predicted[i] = m.Predict(inputs[i])
+	}
+	e.Training = errorMetrics(observed, predicted, len(features))
+
+	order := rand.New(rand.NewSource(evalSeed)).Perm(n)
+
+	var pooledObs, pooledPred []float64
+	for fold := 0; fold < opts.Folds; fold++ {
+		var trainRows, test []int
+		for i, record := range order {
+			if i%opts.Folds == fold {
+				test = append(test, record)
+			} else {
+				trainRows = append(trainRows, record)
+			}
+		}
+		fm, _, err := fit(subset(records, trainRows), features, train.FitOptions)
+		if err != nil {
+			log.Println("model: skipping fold", fold+1, err)
+			continue
+		}
+		obs := make([]float64, 0, len(test))
+		pred := make([]float64, 0, len(test))
+		for _, record := range test {
+			p := fm.Predict(inputs[record])
+			obs = append(obs, observed[record])
+			pred = append(pred, p)
+			e.Residuals = append(e.Residuals, Residual{
+				Record:    record + 1,
+				Fold:      fold + 1,
+				Observed:  observed[record],
+				Predicted: p,
+				Residual:  observed[record] - p,
+			})
+		}
+		e.FoldMetrics = append(e.FoldMetrics, errorMetrics(obs, pred, len(features)))
+		pooledObs = append(pooledObs, obs...)
+		pooledPred = append(pooledPred, pred...)
+	}
+	if len(pooledObs) > 0 {
+		cv := errorMetrics(pooledObs, pooledPred, len(features))
+		e.CrossValidation = &cv
+	}
+
+	testSize := int(math.Round(float64(n) * opts.Holdout))
+	if testSize < 1 {
+		testSize = 1
+	}
+	trainRows, test := order[:n-testSize], order[n-testSize:]
+	hm, _, err := fit(subset(records, trainRows), features, train.FitOptions)
+	if err != nil {
+		log.Println("model: skipping holdout", err)
+	} else {
+		obs := make([]float64, 0, len(test))
+		pred := make([]float64, 0, len(test))
+		for _, record := range test {
+			obs = append(obs, observed[record])
+			pred = append(pred, hm.Predict(inputs[record]))
+		}
+		holdout := errorMetrics(obs, pred, len(features))
+		e.Holdout = &holdout
+	}
+
+	return e, nil
+}
+
+// WriteResidualsCSV writes the out-of-fold residuals as CSV with a header
+// row, ready for plotting.
+func (e *Evaluation) WriteResidualsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"record", "fold", "observed", "predicted", "residual"})
+	for _, r := range e.Residuals {
+		cw.Write([]string{
+			strconv.Itoa(r.Record),
+			strconv.Itoa(r.Fold),
+			strconv.FormatFloat(r.Observed, 'g', -1, 64),
+			strconv.FormatFloat(r.Predicted, 'g', -1, 64),
+			strconv.FormatFloat(r.Residual, 'g', -1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseRecords splits records in the [power, features...] layout into the
+// observed power and the feature maps Predict takes.
+func parseRecords(records [][]string, features []string) ([]float64, []map[string]float64, error) {
+	observed := make([]float64, 0, len(records))
+	inputs := make([]map[string]float64, 0, len(records))
+	for _, record := range records {
+		if len(record) < len(features)+1 {
+			return nil, nil, errors.New("record is missing feature columns")
+		}
+		p, err := strconv.ParseFloat(record[0], 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		input := make(map[string]float64, len(features))
+		for j, name := range features {
+			v, err := strconv.ParseFloat(record[j+1], 64)
+			if err != nil {
+				return nil, nil, err
+			}
+			input[name] = v
+		}
+		observed = append(observed, p)
+		inputs = append(inputs, input)
+	}
+	return observed, inputs, nil
+}
+
+func subset(records [][]string, index []int) [][]string {
+	out := make([][]string, 0, len(index))
+	for _, i := range index {
+		out = append(out, records[i])
+	}
+	return out
+}
+
+// errorMetrics compares predictions of a model with p features against
+// the observed values.
+func errorMetrics(observed, predicted []float64, p int) ErrorMetrics {
+	n := len(observed)
+	m := ErrorMetrics{N: n}
+	if n == 0 {
+		return m
+	}
+
+	mean := 0.0
+	for _, v := range observed {
+		mean += v
+	}
+	mean /= float64(n)
+
+	var ssRes, ssTot, absTotal, pctTotal float64
+	pctCount := 0
+	for i, obs := range observed {
+		res := obs - predicted[i]
+		ssRes += res * res
+		ssTot += (obs - mean) * (obs - mean)
+		absTotal += math.Abs(res)
+		if obs != 0 {
+			pctTotal += math.Abs(res / obs)
+			pctCount++
+		}
+	}
+
+	if ssTot > 0 {
+		m.R2 = 1 - ssRes/ssTot
+		m.AdjustedR2 = m.R2
+		if n-p-1 > 0 {
+			m.AdjustedR2 = 1 - (1-m.R2)*float64(n-1)/float64(n-p-1)
+		}
+	}
+	m.RMSE = math.Sqrt(ssRes / float64(n))
+	m.MAE = absTotal / float64(n)
+	if pctCount > 0 {
+		m.MAPE = 100 * pctTotal / float64(pctCount)
+	}
+	return m
+}
@@ -0,0 +1,76 @@
+package power
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"testing"
+)
+
+func TestEvaluateKnownNoise(t *testing.T) {
+	// Gaussian noise of 2 W on top of the synthetic relationship: a good
+	// fit is off by the noise alone, so RMSE comes out near 2 W and MAE
+	// near 2*sqrt(2/pi) W. The features explain a variance of about
+	// 153.75 W², which leaves R² near 1 - 4/157.75.
+	const noise = 2.0
+	records := syntheticRecords(500, noise)
+	m, err := Train(records, DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := m.Evaluation
+
+	if e.Folds != DefaultFolds || len(e.FoldMetrics) != DefaultFolds {
+		t.Fatalf("%d folds with %d reports, want %d", e.Folds, len(e.FoldMetrics), DefaultFolds)
+	}
+	if e.CrossValidation == nil || e.Holdout == nil {
+		t.Fatalf("evaluation %+v lacks cross-validation or holdout", e)
+	}
+	if e.CrossValidation.N != 500 || e.Holdout.N != 100 {
+		t.Errorf("scored %d out-of-fold and %d holdout records, want 500 and 100", e.CrossValidation.N, e.Holdout.N)
+	}
+
+	checks := []struct {
+		name      string
+		got, want float64
+		tolerance float64
+	}{
+		{"cross-validated RMSE", e.CrossValidation.RMSE, noise, 0.15},
+		{"cross-validated MAE", e.CrossValidation.MAE, noise * math.Sqrt(2/math.Pi), 0.15},
+		{"holdout RMSE", e.Holdout.RMSE, noise, 0.4},
+		{"training R2", e.Training.R2, 1 - noise*noise/157.75, 0.01},
+		{"cross-validated R2", e.CrossValidation.R2, 1 - noise*noise/157.75, 0.01},
+	}
+	for _, c := range checks {
+		if !near(c.got, c.want, c.tolerance) {
+			t.Errorf("%s = %.4f, want %.4f ± %.2f", c.name, c.got, c.want, c.tolerance)
+		}
+	}
+	// Out-of-fold predictions cannot beat the in-sample fit.
+	if e.CrossValidation.RMSE < e.Training.RMSE {
+		t.Errorf("cross-validated RMSE %.4f below the training RMSE %.4f", e.CrossValidation.RMSE, e.Training.RMSE)
+	}
+	if e.Training.AdjustedR2 >= e.Training.R2 {
+		t.Errorf("adjusted R2 %.5f not below R2 %.5f", e.Training.AdjustedR2, e.Training.R2)
+	}
+
+	// Every record is predicted out of fold exactly once.
+	seen := make(map[int]bool)
+	for _, r := range e.Residuals {
+		if seen[r.Record] {
+			t.Fatalf("record %d predicted out of fold twice", r.Record)
+		}
+		seen[r.Record] = true
+	}
+	var buf bytes.Buffer
+	if err := e.WriteResidualsCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 500 || len(rows) != 501 {
+		t.Errorf("%d records in the residuals and %d CSV rows, want 500 and a header more", len(seen), len(rows))
+	}
+}
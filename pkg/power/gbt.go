@@ -0,0 +1,214 @@
+package power
+
+import (
+	"errors"
+	"sort"
+)
+
+// Defaults for the gradient-boosting settings of FitOptions.
+const (
+	DefaultTrees        = 100
+	DefaultDepth        = 3
+	DefaultLearningRate = 0.1
+	DefaultMinLeaf      = 2
+)
+
+// PowerModel predicts power in watts from named features. Model implements
+// it for every model type, and Ensemble for the trees of one: a
+// gradient-boosted Model predicts through its Ensemble.
+type PowerModel interface {
+	Predict(features map[string]float64) float64
+	Features() []string
+}
+
+var (
+	_ PowerModel = (*Model)(nil)
+	_ PowerModel = (*Ensemble)(nil)
+)
+
+// TreeNode is one node of a regression tree. Leaves have Feature -1 and
+// hold Value; inner nodes send features below Threshold to Left and the
+// rest to Right, both indexes into the tree's node list.
+type TreeNode struct {
+	Feature   int     `json:"feature"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Left      int     `json:"left,omitempty"`
+	Right     int     `json:"right,omitempty"`
+	Value     float64 `json:"value,omitempty"`
+}
+
+// Ensemble is a gradient-boosted sum of regression trees fitted to
+// squared error on raw feature values: Base plus LearningRate times the
+// output of every tree.
+type Ensemble struct {
+	FeatureNames []string     `json:"features"`
+	Base         float64      `json:"base"`
+	LearningRate float64      `json:"learningRate"`
+	Depth        int          `json:"depth"`
+	Trees        [][]TreeNode `json:"trees"`
+}
+
+// Predict returns the watts the ensemble expects for the features.
+func (e *Ensemble) Predict(features map[string]float64) float64 {
+	x := make([]float64, len(e.FeatureNames))
+	for i, name := range e.FeatureNames {
+		x[i] = features[name]
+	}
+	watts := e.Base
+	for _, tree := range e.Trees {
+		watts += e.LearningRate * evalTree(tree, x)
+	}
+	return watts
+}
+
+// Features returns the names of the features the ensemble splits on.
+func (e *Ensemble) Features() []string {
+	return e.FeatureNames
+}
+
+func evalTree(tree []TreeNode, x []float64) float64 {
+	node := tree[0]
+	for node.Feature >= 0 {
+		if x[node.Feature] < node.Threshold {
+			node = tree[node.Left]
+		} else {
+			node = tree[node.Right]
+		}
+	}
+	return node.Value
+}
+
+// gbtOptions are the boosting settings of FitOptions with defaults applied.
+type gbtOptions struct {
+	trees, depth, minLeaf int
+	learningRate          float64
+}
+
+// fitEnsemble boosts trees on records in the [power, features...] layout.
+func fitEnsemble(features []string, records [][]string, opts gbtOptions) (*Ensemble, error) {
+	y, inputs, err := parseRecords(records, features)
+	if err != nil {
+		return nil, err
+	}
+	if len(y) < 2*opts.minLeaf {
+		return nil, errors.New("too few records for a regression tree")
+	}
+	x := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		x[i] = make([]float64, len(features))
+		for j, name := range features {
+			x[i][j] = input[name]
+		}
+	}
+
+	e := &Ensemble{
+		FeatureNames: features,
+		LearningRate: opts.learningRate,
+		Depth:        opts.depth,
+	}
+	for _, v := range y {
+		e.Base += v
+	}
+	e.Base /= float64(len(y))
+
+	current := make([]float64, len(y))
+	for i := range current {
+		current[i] = e.Base
+	}
+	residual := make([]float64, len(y))
+	index := make([]int, len(y))
+	for round := 0; round < opts.trees; round++ {
+		for i := range y {
+			residual[i] = y[i] - current[i]
+			index[i] = i
+		}
+		b := &treeBuilder{x: x, y: residual, maxDepth: opts.depth, minLeaf: opts.minLeaf}
+		b.grow(index, 0)
+		for i := range current {
+			current[i] += e.LearningRate * evalTree(b.nodes, x[i])
+		}
+		e.Trees = append(e.Trees, b.nodes)
+	}
+	return e, nil
+}
+
+// treeBuilder grows one least-squares regression tree.
+type treeBuilder struct {
+	x        [][]float64
+	y        []float64
+	maxDepth int
+	minLeaf  int
+	nodes    []TreeNode
+}
+
+// grow adds the subtree for the rows in index and returns its node index.
+func (b *treeBuilder) grow(index []int, depth int) int {
+	at := len(b.nodes)
+	b.nodes = append(b.nodes, TreeNode{Feature: -1, Value: b.mean(index)})
+	if depth >= b.maxDepth || len(index) < 2*b.minLeaf {
+		return at
+	}
+
+	feature, threshold, ok := b.split(index)
+	if !ok {
+		return at
+	}
+	var left, right []int
+	for _, i := range index {
+		if b.x[i][feature] < threshold {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+	l := b.grow(left, depth+1)
+	r := b.grow(right, depth+1)
+	b.nodes[at] = TreeNode{Feature: feature, Threshold: threshold, Left: l, Right: r}
+	return at
+}
+
+func (b *treeBuilder) mean(index []int) float64 {
+	if len(index) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, i := range index {
+		total += b.y[i]
+	}
+	return total / float64(len(index))
+}
+
+// split finds the feature and threshold that most reduce the squared error
+// of the rows in index while leaving minLeaf rows on each side.
+func (b *treeBuilder) split(index []int) (feature int, threshold float64, ok bool) {
+	n := len(index)
+	total, totalSq := 0.0, 0.0
+	for _, i := range index {
+		total += b.y[i]
+		totalSq += b.y[i] * b.y[i]
+	}
+	best := totalSq - total*total/float64(n)
+
+	sorted := make([]int, n)
+	for f := range b.x[index[0]] {
+		copy(sorted, index)
+		sort.Slice(sorted, func(a, c int) bool { return b.x[sorted[a]][f] < b.x[sorted[c]][f] })
+
+		leftSum, leftSq := 0.0, 0.0
+		for k := 0; k < n-1; k++ {
+			v := b.y[sorted[k]]
+			leftSum += v
+			leftSq += v * v
+			lo, hi := b.x[sorted[k]][f], b.x[sorted[k+1]][f]
+			if k+1 < b.minLeaf || n-k-1 < b.minLeaf || lo == hi {
+				continue
+			}
+			rightSum, rightSq := total-leftSum, totalSq-leftSq
+			sse := leftSq - leftSum*leftSum/float64(k+1) + rightSq - rightSum*rightSum/float64(n-k-1)
+			if sse < best-1e-12 {
+				best, feature, threshold, ok = sse, f, (lo+hi)/2, true
+			}
+		}
+	}
+	return feature, threshold, ok
+}
@@ -0,0 +1,110 @@
+package power
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// stepWatts is a machine that draws 25 W more once CPU passes 50%, which
+// no line through cpu and memory can follow.
+func stepWatts(cpu, mem float64) float64 {
+	watts := 20 + 0.1*mem
+	if cpu > 50 {
+		watts += 25
+	}
+	return watts
+}
+
+// stepRecords returns records in the [power, cpu, mem] layout drawn from
+// stepWatts with a little Gaussian noise.
+func stepRecords(n int, seed int64) [][]string {
+	rng := rand.New(rand.NewSource(seed))
+	records := make([][]string, n)
+	for i := range records {
+		cpu := 100 * rng.Float64()
+		mem := 20 + 60*rng.Float64()
+		records[i] = []string{
+			strconv.FormatFloat(stepWatts(cpu, mem)+0.3*rng.NormFloat64(), 'f', -1, 64),
+			strconv.FormatFloat(cpu, 'f', -1, 64),
+			strconv.FormatFloat(mem, 'f', -1, 64),
+		}
+	}
+	return records
+}
+
+// heldOutRMSE returns the root mean squared error of m against stepWatts
+// on fresh inputs.
+func heldOutRMSE(m PowerModel) float64 {
+	rng := rand.New(rand.NewSource(99))
+	sum := 0.0
+	const n = 500
+	for i := 0; i < n; i++ {
+		cpu := 100 * rng.Float64()
+		mem := 20 + 60*rng.Float64()
+		e := m.Predict(map[string]float64{FeatureCPU: cpu, FeatureMemory: mem}) - stepWatts(cpu, mem)
+		sum += e * e
+	}
+	return math.Sqrt(sum / n)
+}
+
+func TestGBTBeatsLinearOnStep(t *testing.T) {
+	records := stepRecords(400, 3)
+	linear, err := Train(records, DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gbt, err := Train(records, DefaultFeatures, TrainOptions{FitOptions: FitOptions{Type: TypeGBT}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gbt.Type != TypeGBT || gbt.Ensemble == nil || len(gbt.Ensemble.Trees) != DefaultTrees {
+		t.Fatalf("trained %s model with %+v, want %d trees", gbt.Type, gbt.Ensemble, DefaultTrees)
+	}
+
+	linearRMSE, gbtRMSE := heldOutRMSE(linear), heldOutRMSE(gbt)
+	if gbtRMSE > 2 || gbtRMSE > linearRMSE/2 {
+		t.Errorf("held-out RMSE = %.2f W for GBT and %.2f W for linear, want GBT under 2 W and half of linear", gbtRMSE, linearRMSE)
+	}
+	if gbt.R2 <= linear.R2 {
+		t.Errorf("R2 = %.4f for GBT and %.4f for linear, want GBT higher", gbt.R2, linear.R2)
+	}
+}
+
+func TestGBTModelStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ms, err := OpenModelStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.SaveDataset("step", stepRecords(200, 4)); err != nil {
+		t.Fatal(err)
+	}
+	trained, err := ms.Train("step", nil, TrainOptions{FitOptions: FitOptions{Type: TypeGBT, Trees: 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second store reads the model back from its JSON file.
+	reopened, err := OpenModelStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := reopened.Get(trained.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Ensemble, trained.Ensemble) {
+		t.Fatal("the ensemble changed on its way through the store")
+	}
+	for cpu := 0.0; cpu <= 100; cpu += 2.5 {
+		for mem := 20.0; mem <= 80; mem += 15 {
+			x := map[string]float64{FeatureCPU: cpu, FeatureMemory: mem}
+			if got, want := loaded.Predict(x), trained.Predict(x); got != want {
+				t.Errorf("Predict(cpu %.1f, mem %.1f) = %v after loading, want %v", cpu, mem, got, want)
+			}
+		}
+	}
+}
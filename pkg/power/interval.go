@@ -0,0 +1,150 @@
+package power
+
+import (
+	"math"
+
+	"analysis-model/pkg/analysis"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// intervalLevel is the coverage of the prediction intervals.
+const intervalLevel = 0.95
+
+// Uncertainty is what a prediction interval needs from training, in the
+// normalised space of the model: the residual variance with its degrees
+// of freedom and the inverse of X'WX, where X holds a leading 1 for the
+// intercept followed by Features in order.
+type Uncertainty struct {
+	Features         []string    `json:"features"`
+	ResidualVariance float64     `json:"residualVariance"`
+	DF               int         `json:"df"`
+	Covariance       [][]float64 `json:"covariance"`
+}
+
+// newUncertainty computes the uncertainty of the fit beta on data. Rows
+// with weight 0 do not count; nil weights count every row fully. It
+// returns nil when there are too few rows or X'WX is singular.
+func newUncertainty(data *normalized, features []string, beta, weights []float64) *Uncertainty {
+	n, p := len(data.y), len(features)+1
+	if weights == nil {
+		weights = make([]float64, n)
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	xtx := mat.NewSymDense(p, nil)
+	used := 0
+	ssr := 0.0
+	res := residuals(data, beta)
+	for i, row := range data.x {
+		w := weights[i]
+		if w == 0 {
+			continue
+		}
+		used++
+		ssr += w * res[i] * res[i]
+		x := append([]float64{1}, row...)
+		for a := 0; a < p; a++ {
+			for b := a; b < p; b++ {
+				xtx.SetSym(a, b, xtx.At(a, b)+w*x[a]*x[b])
+			}
+		}
+	}
+	df := used - p
+	if df < 1 {
+		return nil
+	}
+
+	var inv mat.Dense
+	if err := inv.Inverse(xtx); err != nil {
+		return nil
+	}
+	u := &Uncertainty{
+		Features:         features,
+		ResidualVariance: ssr / float64(df),
+		DF:               df,
+		Covariance:       make([][]float64, p),
+	}
+	for a := range u.Covariance {
+		u.Covariance[a] = make([]float64, p)
+		for b := range u.Covariance[a] {
+			u.Covariance[a][b] = inv.At(a, b)
+		}
+	}
+	return u
+}
+
+// row returns the normalised design row of a set of features.
+func (m *Model) row(features map[string]float64) []float64 {
+	x := make([]float64, len(m.Uncertainty.Features)+1)
+	x[0] = 1
+	for i, name := range m.Uncertainty.Features {
+		x[i+1] = m.normalize(name, features[name])
+	}
+	return x
+}
+
+// quadratic returns x' C x for the covariance C of the model.
+func (m *Model) quadratic(x []float64) float64 {
+	q := 0.0
+	for a, row := range m.Uncertainty.Covariance {
+		for b, c := range row {
+			q += x[a] * c * x[b]
+		}
+	}
+	return q
+}
+
+// powerScale converts normalised power differences to watts.
+func (m *Model) powerScale() float64 {
+	r, ok := m.Normalization[FeaturePower]
+	if !ok {
+		return 1
+	}
+	return r.Max - r.Min
+}
+
+// PredictInterval returns the predicted watts with a 95% prediction
+// interval for a single new reading. The interval is nil for models
+// without training uncertainty, such as the built-in default.
+func (m *Model) PredictInterval(features map[string]float64) (float64, *analysis.Interval) {
+	watts := m.Predict(features)
+	if m.Uncertainty == nil {
+		return watts, nil
+	}
+	x := m.row(features)
+	se := math.Sqrt(m.Uncertainty.ResidualVariance*(1+m.quadratic(x))) * m.powerScale()
+	half := analysis.TCritical95(m.Uncertainty.DF) * se
+	return watts, &analysis.Interval{Low: watts - half, High: watts + half, Level: intervalLevel}
+}
+
+// EnergyInterval returns a 95% prediction interval for energy computed as
+// the weighted sum of the predicted power of every reading, with weights
+// such as analysis.TrapezoidWeights. The error of the coefficients is
+// shared by all readings while the residual noise of each is independent.
+func (m *Model) EnergyInterval(weights []float64, inputs []map[string]float64) *analysis.Interval {
+	if m.Uncertainty == nil || len(weights) != len(inputs) {
+		return nil
+	}
+	var g []float64
+	joules, noise := 0.0, 0.0
+	for i, features := range inputs {
+		x := m.row(features)
+		if g == nil {
+			g = make([]float64, len(x))
+		}
+		for j := range x {
+			g[j] += weights[i] * x[j]
+		}
+		noise += weights[i] * weights[i]
+		joules += weights[i] * m.Predict(features)
+	}
+	if g == nil {
+		return nil
+	}
+	se := math.Sqrt(m.Uncertainty.ResidualVariance*(m.quadratic(g)+noise)) * m.powerScale()
+	half := analysis.TCritical95(m.Uncertainty.DF) * se
+	return &analysis.Interval{Low: joules - half, High: joules + half, Level: intervalLevel}
+}
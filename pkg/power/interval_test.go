@@ -0,0 +1,72 @@
+package power
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"analysis-model/pkg/analysis"
+)
+
+// reading draws one input of the synthetic relationship and its noisy
+// measured watts.
+func reading(rng *rand.Rand, noise float64) (map[string]float64, float64) {
+	cpu := 100 * rng.Float64()
+	mem := 20 + 60*rng.Float64()
+	return map[string]float64{FeatureCPU: cpu, FeatureMemory: mem}, trueA*cpu + trueB*mem + trueC + noise*rng.NormFloat64()
+}
+
+func TestIntervalCoverage(t *testing.T) {
+	const (
+		trials = 400
+		noise  = 1.5
+	)
+	rng := rand.New(rand.NewSource(11))
+	start := time.Now()
+	times := make([]time.Time, 10)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Second)
+	}
+	weights := analysis.TrapezoidWeights(times)
+
+	// Every trial trains on fresh data, so the coverage counts the error
+	// of the coefficients as well as the noise of the held-out readings.
+	var predictions, energies int
+	for trial := 0; trial < trials; trial++ {
+		records := make([][]string, 40)
+		for i := range records {
+			x, watts := reading(rng, noise)
+			records[i] = []string{
+				strconv.FormatFloat(watts, 'f', -1, 64),
+				strconv.FormatFloat(x[FeatureCPU], 'f', -1, 64),
+				strconv.FormatFloat(x[FeatureMemory], 'f', -1, 64),
+			}
+		}
+		m, err := Train(records, DefaultFeatures, TrainOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x, watts := reading(rng, noise)
+		if _, in := m.PredictInterval(x); in.Low <= watts && watts <= in.High {
+			predictions++
+		}
+
+		inputs := make([]map[string]float64, len(times))
+		joules := 0.0
+		for i := range inputs {
+			inputs[i], watts = reading(rng, noise)
+			joules += weights[i] * watts
+		}
+		if in := m.EnergyInterval(weights, inputs); in.Low <= joules && joules <= in.High {
+			energies++
+		}
+	}
+
+	for name, covered := range map[string]int{"PredictInterval": predictions, "EnergyInterval": energies} {
+		if share := float64(covered) / trials; share < 0.92 || share > 0.98 {
+			t.Errorf("%s covered %.1f%% of held-out readings, want about 95%%", name, 100*share)
+		}
+	}
+}
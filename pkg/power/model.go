@@ -0,0 +1,259 @@
+package power
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"analysis-model/pkg/analysis"
+)
+
+// Feature names used by the regression and as coefficient keys. The names
+// of the other features a model can use are defined by package analysis,
+// which samples them.
+const (
+	FeaturePower  = "Power"
+	FeatureCPU    = analysis.FeatureCPU
+	FeatureMemory = analysis.FeatureMemory
+)
+
+// DefaultFeatures is the column layout of a dataset without a header row.
+var DefaultFeatures = []string{FeatureCPU, FeatureMemory}
+
+// DefaultModelID names the built-in model used until one is trained.
+const DefaultModelID = "default"
+
+// Model is one trained version of the linear power model. When
+// Normalization is set, Intercept and Coefficients apply to min-max
+// normalised values, as trained by Regression, and Predict maps features in
+// and watts out of that space. Without it they apply to raw units.
+type Model struct {
+	ID            string             `json:"id"`
+	Version       int                `json:"version"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	Dataset       string             `json:"dataset,omitempty"`
+	Type          string             `json:"type,omitempty"`
+	Method        string             `json:"method,omitempty"`
+	Intercept     float64            `json:"intercept"`
+	Coefficients  map[string]float64 `json:"coefficients"`
+	Normalization map[string]Range   `json:"normalization,omitempty"`
+	R2            float64            `json:"r2"`
+	Observations  int                `json:"observations"`
+	Evaluation    *Evaluation        `json:"evaluation,omitempty"`
+	Uncertainty   *Uncertainty       `json:"uncertainty,omitempty"`
+
+	// Ensemble holds the trees of a gradient-boosted model, which has no
+	// coefficients.
+	Ensemble *Ensemble `json:"ensemble,omitempty"`
+
+	// Parent is the model an online refinement started from and Updates
+	// the number of measured samples folded in since.
+	Parent  string `json:"parent,omitempty"`
+	Updates int    `json:"updates,omitempty"`
+
+	Active bool `json:"active"`
+}
+
+// DefaultModel returns the coefficients the server shipped with before
+// models could be trained.
+func DefaultModel() *Model {
+	return &Model{
+		ID:        DefaultModelID,
+		Intercept: 96.2107,
+		Coefficients: map[string]float64{
+			FeatureCPU:    -0.4059,
+			FeatureMemory: -17.2624,
+		},
+	}
+}
+
+// Predict returns the power in watts the model expects for the given
+// features in their natural units. Features the model was not trained on
+// are ignored.
+func (m *Model) Predict(features map[string]float64) (watts float64) {
+	if m.Ensemble != nil {
+		return m.Ensemble.Predict(features)
+	}
+	watts = m.Intercept
+	for name, coeff := range m.Coefficients {
+		watts += coeff * m.normalize(name, features[name])
+	}
+	return m.denormalize(FeaturePower, watts)
+}
+
+func (m *Model) normalize(name string, v float64) float64 {
+	r, ok := m.Normalization[name]
+	if !ok {
+		return v
+	}
+	return r.normalize(v)
+}
+
+// Features returns the names of the features the model uses, sorted.
+func (m *Model) Features() []string {
+	if m.Ensemble != nil {
+		names := append([]string(nil), m.Ensemble.Features()...)
+		sort.Strings(names)
+		return names
+	}
+	names := make([]string, 0, len(m.Coefficients))
+	for name := range m.Coefficients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Model) denormalize(name string, v float64) float64 {
+	r, ok := m.Normalization[name]
+	if !ok {
+		return v
+	}
+	return r.Min + v*(r.Max-r.Min)
+}
+
+// validateRecords checks that training records have at least the given
+// number of numeric columns.
+func validateRecords(records [][]string, columns int) error {
+	if len(records) < 4 {
+		return errors.New("training needs at least 4 records")
+	}
+	if columns < 2 {
+		return errors.New("training needs power and at least one feature")
+	}
+	for i, record := range records {
+		if len(record) < columns {
+			return fmt.Errorf("record %d: want at least %d columns, got %d", i+1, columns, len(record))
+		}
+		for j, atom := range record {
+			if _, err := strconv.ParseFloat(atom, 64); err != nil {
+				return fmt.Errorf("record %d column %d: %v", i+1, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkVarying checks that each of the leading columns named by names
+// varies, since Regression min-max normalises them. A dataset may hold
+// constant columns, such as a frequency the hardware does not report, as
+// long as no model is trained on them. The records are already validated.
+func checkVarying(records [][]string, names []string) error {
+	for j, name := range names {
+		if constantColumn(records, j) {
+			return fmt.Errorf("column %s is constant", name)
+		}
+	}
+	return nil
+}
+
+// constantColumn reports whether column j holds the same value in every
+// record.
+func constantColumn(records [][]string, j int) bool {
+	first, _ := strconv.ParseFloat(records[0][j], 64)
+	for _, record := range records[1:] {
+		if v, _ := strconv.ParseFloat(record[j], 64); v != first {
+			return false
+		}
+	}
+	return true
+}
+
+// TrainOptions configures fitting and evaluation.
+type TrainOptions struct {
+	FitOptions
+	EvalOptions
+}
+
+// Train fits a model on the named features and evaluates it. Each record
+// holds power in its first column followed by the features in order.
+func Train(records [][]string, features []string, opts TrainOptions) (*Model, error) {
+	var err error
+	opts.FitOptions, err = opts.FitOptions.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	m, outliers, err := fit(records, features, opts.FitOptions)
+	if err != nil {
+		return nil, err
+	}
+	m.Evaluation, err = Evaluate(m, records, features, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.Evaluation.Outliers = outliers
+	return m, nil
+}
+
+// fit runs the regression without evaluating the result. Robust methods
+// also return the rows they found far off the model.
+func fit(records [][]string, features []string, opts FitOptions) (*Model, []Outlier, error) {
+	if err := validateRecords(records, len(features)+1); err != nil {
+		return nil, nil, err
+	}
+	if err := checkVarying(records, append([]string{FeaturePower}, features...)); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Type == TypeGBT {
+		e, err := fitEnsemble(features, records, gbtOptions{
+			trees:        opts.Trees,
+			depth:        opts.Depth,
+			minLeaf:      opts.MinLeaf,
+			learningRate: opts.LearningRate,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		m := &Model{
+			CreatedAt:    time.Now(),
+			Type:         TypeGBT,
+			Ensemble:     e,
+			Observations: len(records),
+		}
+		observed, inputs, _ := parseRecords(records, features)
+		predicted := make([]float64, len(inputs))
+		for i := range inputs {
+			predicted[i] = e.Predict(inputs[i])
+		}
+		m.R2 = errorMetrics(observed, predicted, len(features)).R2
+		return m, nil, nil
+	}
+
+	if opts.Method != MethodOLS {
+		m, outliers, err := robustFit(features, records, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.CreatedAt = time.Now()
+		m.Type = TypeLinear
+		m.Method = opts.Method
+		return m, outliers, nil
+	}
+
+	fp := NewFormula()
+	if err := fp.RegressionFeatures(features, records); err != nil {
+		return nil, nil, err
+	}
+
+	m := &Model{
+		CreatedAt:     time.Now(),
+		Type:          TypeLinear,
+		Method:        MethodOLS,
+		Intercept:     fp.Formula.Intercept,
+		Coefficients:  fp.Formula.Coefficients,
+		Normalization: fp.Formula.Normalization,
+		R2:            fp.Formula.Regression.R2,
+		Observations:  len(records),
+	}
+	if data, err := normalizeRecords(features, records); err == nil {
+		beta := []float64{m.Intercept}
+		for _, name := range features {
+			beta = append(beta, m.Coefficients[name])
+		}
+		m.Uncertainty = newUncertainty(data, features, beta, nil)
+	}
+	return m, nil, nil
+}
@@ -0,0 +1,400 @@
+package power
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+)
+
+const (
+	datasetsDir = "datasets"
+	modelsDir   = "models"
+	activeFile  = "active"
+)
+
+// ErrModelNotFound is returned for an unknown model ID.
+var ErrModelNotFound = errors.New("model not found")
+
+// ErrDatasetNotFound is returned for an unknown dataset name.
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+var datasetName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ModelStore keeps training datasets and trained model versions on disk
+// and remembers which model is active for prediction. Every model is a
+// JSON file under models/ and the active ID is kept in models/active.
+type ModelStore struct {
+	dir string
+
+	mu     sync.RWMutex
+	models map[string]*Model
+	latest int
+	active string
+}
+
+// OpenModelStore loads the models under dir, creating it if needed.
+func OpenModelStore(dir string) (*ModelStore, error) {
+	for _, sub := range []string{datasetsDir, modelsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	ms := &ModelStore{
+		dir:    dir,
+		models: make(map[string]*Model),
+		active: DefaultModelID,
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, modelsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m := &Model{}
+		if err := json.Unmarshal(contents, m); err != nil {
+			log.Println("model: skipping", path, err)
+			continue
+		}
+		ms.models[m.ID] = m
+		if m.Version > ms.latest {
+			ms.latest = m.Version
+		}
+	}
+
+	active, err := ioutil.ReadFile(filepath.Join(dir, modelsDir, activeFile))
+	if err == nil {
+		id := strings.TrimSpace(string(active))
+		if _, ok := ms.models[id]; ok {
+			ms.active = id
+		} else {
+			log.Println("model: active model", id, "is missing, using", DefaultModelID)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	log.Println("model: loaded", len(ms.models), "models, active", ms.active)
+
+	return ms, nil
+}
+
+// SaveDataset stores training records under name, replacing an older
+// dataset of the same name. Power is the first column. A non-numeric first
+// row is a header naming the feature in every other column; without one
+// the records are taken to be in the [power, cpu, mem] layout. Columns
+// may be constant; training rejects them only when a model uses them. It
+// returns the records without the header.
+func (ms *ModelStore) SaveDataset(name string, records [][]string) ([][]string, error) {
+	if !datasetName.MatchString(name) {
+		return nil, fmt.Errorf("invalid dataset name %q", name)
+	}
+	header, records := splitHeader(records)
+	for _, feature := range header[1:] {
+		if !knownFeature(feature) {
+			return nil, fmt.Errorf("unknown feature %q", feature)
+		}
+	}
+	if err := validateRecords(records, len(header)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(filepath.Join(ms.dir, datasetsDir, name+".csv"))
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(append([][]string{header}, records...)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return records, f.Close()
+}
+
+// splitHeader separates the header row from the records, supplying the
+// default header when there is none.
+func splitHeader(records [][]string) (header []string, rest [][]string) {
+	if len(records) > 0 && len(records[0]) > 0 {
+		if _, err := strconv.ParseFloat(records[0][0], 64); err != nil {
+			return records[0], records[1:]
+		}
+	}
+	return append([]string{FeaturePower}, DefaultFeatures...), records
+}
+
+func knownFeature(name string) bool {
+	if name == FeatureCPU || name == FeatureMemory {
+		return true
+	}
+	for _, known := range analysis.ExtraFeatures {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// varyingFeatures returns the features of a dataset header whose columns
+// vary. Constant ones are left out with a warning.
+func varyingFeatures(header []string, records [][]string) []string {
+	features := make([]string, 0, len(header)-1)
+	for j, feature := range header[1:] {
+		if len(records) > 0 && constantColumn(records, j+1) {
+			log.Println("model: leaving out constant feature", feature)
+			continue
+		}
+		features = append(features, feature)
+	}
+	return features
+}
+
+// Dataset reads back the header and records of a stored dataset.
+func (ms *ModelStore) Dataset(name string) (header []string, records [][]string, err error) {
+	if !datasetName.MatchString(name) {
+		return nil, nil, ErrDatasetNotFound
+	}
+	f, err := os.Open(filepath.Join(ms.dir, datasetsDir, name+".csv"))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrDatasetNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	records, err = csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	header, records = splitHeader(records)
+	return header, records, nil
+}
+
+// Datasets lists the names of the stored datasets.
+func (ms *ModelStore) Datasets() []string {
+	paths, err := filepath.Glob(filepath.Join(ms.dir, datasetsDir, "*.csv"))
+	if err != nil {
+		log.Println(err)
+	}
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(path), ".csv"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Train fits a new model version on the named features of a stored
+// dataset and persists it. With no features it uses every feature of the
+// dataset that varies. The new model is not activated.
+func (ms *ModelStore) Train(dataset string, features []string, opts TrainOptions) (*Model, error) {
+	header, records, err := ms.Dataset(dataset)
+	if err != nil {
+		return nil, err
+	}
+	if len(features) == 0 {
+		features = varyingFeatures(header, records)
+	}
+	records, err = selectColumns(header, records, features)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Train(records, features, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.Dataset = dataset
+	if n := len(m.Evaluation.Outliers); n > 0 {
+		log.Println("model:", n, "outlying rows in", dataset)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.latest++
+	m.Version = ms.latest
+	m.ID = "v" + strconv.Itoa(m.Version)
+	if err := ms.write(m); err != nil {
+		ms.latest--
+		return nil, err
+	}
+	ms.models[m.ID] = m
+	log.Println("model: trained", m.Type, m.ID, "on", dataset, "R2", m.R2)
+	if cv := m.Evaluation.CrossValidation; cv != nil {
+		log.Printf("model: %s %s %d-fold RMSE %.3f MAE %.3f MAPE %.2f%% R2 %.4f", m.ID, m.Method, m.Evaluation.Folds, cv.RMSE, cv.MAE, cv.MAPE, cv.R2)
+	}
+
+	return ms.view(m), nil
+}
+
+// Checkpoint persists a model refined online and makes it the active one.
+// A refinement of a trained or built-in model becomes a new version with
+// Parent set; later checkpoints of that version overwrite it.
+func (ms *ModelStore) Checkpoint(m *Model) (*Model, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cp := *m
+	cp.Active = false
+	if existing, ok := ms.models[cp.ID]; !ok || existing.Method != MethodRLS {
+		ms.latest++
+		cp.Parent = cp.ID
+		cp.Version = ms.latest
+		cp.ID = "v" + strconv.Itoa(cp.Version)
+		cp.Method = MethodRLS
+		cp.CreatedAt = time.Now()
+		cp.Evaluation = nil
+	}
+	if err := ms.write(&cp); err != nil {
+		if cp.ID != m.ID {
+			ms.latest--
+		}
+		return nil, err
+	}
+	ms.models[cp.ID] = &cp
+	if err := ms.setActive(cp.ID); err != nil {
+		return nil, err
+	}
+	log.Println("model: checkpointed", cp.ID, "from", cp.Parent, "after", cp.Updates, "updates")
+
+	return ms.view(&cp), nil
+}
+
+// selectColumns reorders records into the [power, features...] layout.
+func selectColumns(header []string, records [][]string, features []string) ([][]string, error) {
+	index := make([]int, 0, len(features)+1)
+	index = append(index, 0)
+	for _, feature := range features {
+		found := -1
+		for i, name := range header[1:] {
+			if name == feature {
+				found = i + 1
+			}
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("dataset has no %s column", feature)
+		}
+		index = append(index, found)
+	}
+
+	selected := make([][]string, 0, len(records))
+	for i, record := range records {
+		row := make([]string, len(index))
+		for j, col := range index {
+			if col >= len(record) {
+				return nil, fmt.Errorf("record %d: missing column %s", i+1, header[col])
+			}
+			row[j] = record[col]
+		}
+		selected = append(selected, row)
+	}
+	return selected, nil
+}
+
+// write persists a model. The caller holds ms.mu.
+func (ms *ModelStore) write(m *Model) error {
+	contents, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ms.dir, modelsDir, m.ID+".json"), contents)
+}
+
+// writeFileAtomic replaces path through a rename so a crash never leaves a
+// half-written file behind.
+func writeFileAtomic(path string, contents []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// view returns a copy of m with Active filled in. The caller holds ms.mu.
+func (ms *ModelStore) view(m *Model) *Model {
+	v := *m
+	v.Active = m.ID == ms.active
+	return &v
+}
+
+// List returns every model version, the built-in default first.
+func (ms *ModelStore) List() []*Model {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	list := []*Model{ms.view(DefaultModel())}
+	ids := make([]*Model, 0, len(ms.models))
+	for _, m := range ms.models {
+		ids = append(ids, m)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Version < ids[j].Version })
+	for _, m := range ids {
+		list = append(list, ms.view(m))
+	}
+	return list
+}
+
+// Get returns one model version.
+func (ms *ModelStore) Get(id string) (*Model, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if id == DefaultModelID {
+		return ms.view(DefaultModel()), nil
+	}
+	m, ok := ms.models[id]
+	if !ok {
+		return nil, ErrModelNotFound
+	}
+	return ms.view(m), nil
+}
+
+// Activate makes a model the one used for prediction.
+func (ms *ModelStore) Activate(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.models[id]; !ok && id != DefaultModelID {
+		return ErrModelNotFound
+	}
+	if err := ms.setActive(id); err != nil {
+		return err
+	}
+	log.Println("model: activated", id)
+	return nil
+}
+
+// setActive records the active model ID. The caller holds ms.mu.
+func (ms *ModelStore) setActive(id string) error {
+	if err := writeFileAtomic(filepath.Join(ms.dir, modelsDir, activeFile), []byte(id+"\n")); err != nil {
+		return err
+	}
+	ms.active = id
+	return nil
+}
+
+// Active returns the model used for prediction.
+func (ms *ModelStore) Active() *Model {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if m, ok := ms.models[ms.active]; ok {
+		return ms.view(m)
+	}
+	return ms.view(DefaultModel())
+}
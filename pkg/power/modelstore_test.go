@@ -0,0 +1,69 @@
+package power
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"analysis-model/pkg/analysis"
+)
+
+// calibrationRecords is a calibrate CSV from a machine that reports no CPU
+// frequency, whose CpuFreq column is therefore constant.
+func calibrationRecords() [][]string {
+	records := [][]string{{FeaturePower, FeatureCPU, FeatureMemory, analysis.FeatureCPUFreq}}
+	for _, record := range syntheticRecords(50, 0.05) {
+		records = append(records, append(record, "0"))
+	}
+	return records
+}
+
+func TestTrainConstantFeature(t *testing.T) {
+	ms, err := OpenModelStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.SaveDataset("calibration", calibrationRecords()); err != nil {
+		t.Fatalf("SaveDataset rejected a constant column: %v", err)
+	}
+
+	m, err := ms.Train("calibration", nil, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{FeatureCPU, FeatureMemory}; !reflect.DeepEqual(m.Features(), want) {
+		t.Errorf("trained on %v, want %v", m.Features(), want)
+	}
+
+	_, err = ms.Train("calibration", []string{FeatureCPU, analysis.FeatureCPUFreq}, TrainOptions{})
+	if err == nil || !strings.Contains(err.Error(), analysis.FeatureCPUFreq) {
+		t.Errorf("training on the constant column: err = %v, want it named", err)
+	}
+}
+
+func TestUnknownFeatureRejected(t *testing.T) {
+	ms, err := OpenModelStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := append([][]string{{FeaturePower, FeatureCPU, "GpuWatts"}}, syntheticRecords(20, 0.05)...)
+	_, err = ms.SaveDataset("gpu", records)
+	if err == nil || !strings.Contains(err.Error(), "GpuWatts") {
+		t.Errorf("SaveDataset with a GpuWatts column: err = %v, want it named", err)
+	}
+	if names := ms.Datasets(); len(names) != 0 {
+		t.Errorf("rejected dataset was stored as %v", names)
+	}
+
+	if _, err := ms.SaveDataset("calibration", calibrationRecords()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ms.Train("calibration", []string{FeatureCPU, "GpuWatts"}, TrainOptions{})
+	if err == nil || !strings.Contains(err.Error(), "GpuWatts") {
+		t.Errorf("training on GpuWatts: err = %v, want it named", err)
+	}
+	if models := ms.List(); len(models) != 1 {
+		t.Errorf("store holds %d models after the rejected training, want only the default", len(models))
+	}
+}
@@ -22,107 +22,132 @@ func NewFormula() *FormulaProvider {
 	return fp
 }
 
+// Regression fits power against CPU and memory on min-max normalised
+// records in the [power, cpu, mem] layout. The normalised coefficients and
+// the ranges used are kept in fp.Formula; the returned coefficients are
+// converted back to watts.
 func (fp *FormulaProvider) Regression(start [][]string) (a float64, b float64, intercept float64) {
-	records := make([][]float64, 0, len(start))
-	powerslice := make([]float64, 0, len(start))
-	cpuslice := make([]float64, 0, len(start))
-	memslice := make([]float64, 0, len(start))
-	for i, s := range start {
-		r := make([]float64, 0, len(s))
-		for _, atom := range s {
-			record, err := strconv.ParseFloat(atom, 64)
-			if err != nil {
-				log.Println(err)
-			}
-			r = append(r, record)
-		}
-		fmt.Println(r)
-		records = append(records, r)
-		powerslice = append(powerslice, records[i][0])
-		cpuslice = append(cpuslice, records[i][1])
-		memslice = append(memslice, records[i][2])
-	}
-	powerMax := floats.Max(powerslice)
-	powerMin := floats.Min(powerslice)
-	cpuMax := floats.Max(cpuslice)
-	cpuMin := floats.Min(cpuslice)
-
-	memMax := floats.Max(memslice)
-	memMin := floats.Min(memslice)
-
-	fmt.Println(powerMax)
-	fmt.Println(memMin)
-	log.Println("recieve records -> len =", len(records))
-
-	fp.Formula.Regression.SetObserved("Power")
-	fp.Formula.Regression.SetVar(0, "Cpu")
-	fp.Formula.Regression.SetVar(1, "Memory")
-
-	for i, record := range records {
-		power := records[i][0]
-		power = (power - powerMin) / (powerMax - powerMin)
-		cpu := record[1]
-		cpu = (cpu - cpuMin) / (cpuMax - cpuMin)
-		memory := record[2]
-		memory = (memory - memMin) / (memMax - memMin)
-
-		fp.Formula.Regression.Train(regression.DataPoint(power, []float64{cpu, memory}))
-	}
-	// Train/fit the regression model.
-	err := fp.Formula.Regression.Run()
+	log.Println("recieve records -> len =", len(start))
+	err := fp.RegressionFeatures([]string{FeatureCPU, FeatureMemory}, start)
 	if err != nil {
 		log.Println(err)
+		return 0, 0, 0
 	}
 	fmt.Printf("\nRegression Formula:\n%v\n\n", fp.Formula.Regression.Formula)
-	err = fp.Formula.getCoefficient(fp.Formula.Regression.Formula)
-	if err != nil {
-		log.Println(err)
-	}
-
-	return a, b, intercept
+	coeffs, intercept := fp.Formula.Raw()
+	return coeffs[FeatureCPU], coeffs[FeatureMemory], intercept
 }
 
-func (f *formula) getCoefficient(formula string) (err error) {
-	temp := strings.Split(formula, " = ")
-	spstring := strings.Split(temp[1], " + ")
-	f.Intercept, err = strconv.ParseFloat(spstring[0], 64)
+// RegressionFeatures fits power against the named features. Each record
+// holds power in its first column followed by the features in order. Every
+// column is min-max normalised before fitting, and the normalised
+// coefficients are kept in fp.Formula keyed by feature name, with the first
+// four also in the Alpha, Beta, gamma and delta slots.
+func (fp *FormulaProvider) RegressionFeatures(features []string, start [][]string) error {
+	data, err := normalizeRecords(features, start)
 	if err != nil {
 		return err
 	}
-	spstring = spstring[1:]
-	for _, atom := range spstring {
-		temp := strings.Split(atom, "*")
-		metric := temp[0]
-		strValue := temp[1]
-		switch metric {
-		case "Cpu":
-			f.Alpha, err = strconv.ParseFloat(strValue, 64)
-			if err != nil {
-				return err
-			}
-			log.Println(f.Alpha)
 
-		case "Memory":
-			f.Beta, err = strconv.ParseFloat(strValue, 64)
+	f := &fp.Formula
+	f.Regression = regression.Regression{}
+	f.Regression.SetObserved(FeaturePower)
+	for i, name := range features {
+		f.Regression.SetVar(i, name)
+	}
+	for i := range data.y {
+		f.Regression.Train(regression.DataPoint(data.y[i], data.x[i]))
+	}
+	if err := f.Regression.Run(); err != nil {
+		return err
+	}
+
+	f.Features = features
+	f.Normalization = data.ranges
+	f.Intercept = f.Regression.Coeff(0)
+	f.Coefficients = make(map[string]float64, len(features))
+	slots := []*float64{&f.Alpha, &f.Beta, &f.gamma, &f.delta}
+	for i, name := range features {
+		f.Coefficients[name] = f.Regression.Coeff(i + 1)
+		if i < len(slots) {
+			*slots[i] = f.Coefficients[name]
+		}
+	}
+	fp.HasFormula = true
+	return nil
+}
+
+// normalized holds training records min-max normalised column by column:
+// y is the power and every row of x holds the features in order.
+type normalized struct {
+	ranges map[string]Range
+	y      []float64
+	x      [][]float64
+}
+
+// normalizeRecords parses records in the [power, features...] layout and
+// normalises every column, which must not be constant. A non-numeric first
+// row is a header, as written by the calibrator, and is skipped.
+func normalizeRecords(features []string, start [][]string) (*normalized, error) {
+	_, start = splitHeader(start)
+	columns := len(features) + 1
+	values := make([][]float64, columns)
+	for i, s := range start {
+		if len(s) < columns {
+			return nil, fmt.Errorf("record %d: want %d columns, got %d", i+1, columns, len(s))
+		}
+		for j := 0; j < columns; j++ {
+			v, err := strconv.ParseFloat(s[j], 64)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("record %d column %d: %v", i+1, j+1, err)
 			}
-			log.Println(f.Beta)
+			values[j] = append(values[j], v)
 		}
-
 	}
 
-	return nil
-
+	names := append([]string{FeaturePower}, features...)
+	data := &normalized{ranges: make(map[string]Range, columns)}
+	for j, name := range names {
+		r := Range{Min: floats.Min(values[j]), Max: floats.Max(values[j])}
+		if r.Max == r.Min {
+			return nil, fmt.Errorf("column %s is constant", name)
+		}
+		data.ranges[name] = r
+	}
+	for i := range start {
+		data.y = append(data.y, data.ranges[FeaturePower].normalize(values[0][i]))
+		row := make([]float64, len(features))
+		for j, name := range features {
+			row[j] = data.ranges[name].normalize(values[j+1][i])
+		}
+		data.x = append(data.x, row)
+	}
+	return data, nil
 }
 
-func (fp *FormulaProvider) GetPower(powerchan chan float64) {
+// Raw converts the normalised fit back to coefficients in natural units.
+// With p = pMin + pRange*pn and x = xMin + xRange*xn, the normalised fit
+// pn = I + sum(C*xn) becomes p = intercept + sum(c*x).
+func (f *formula) Raw() (coeffs map[string]float64, intercept float64) {
+	power := f.Normalization[FeaturePower]
+	powerRange := power.Max - power.Min
+	coeffs = make(map[string]float64, len(f.Coefficients))
+	intercept = power.Min + powerRange*f.Intercept
+	for name, coeff := range f.Coefficients {
+		r := f.Normalization[name]
+		coeffs[name] = powerRange * coeff / (r.Max - r.Min)
+		intercept -= coeffs[name] * r.Min
+	}
+	return coeffs, intercept
+}
 
+// ReadPower takes one turbostat PkgWatt reading.
+func (fp *FormulaProvider) ReadPower() (float64, error) {
 	cmd := exec.Command("turbostat", "--Summary", "-i", "1", "-n", "1", "-s", "PkgWatt")
 
 	out, err := cmd.Output()
 	if err != nil {
-		fmt.Println(err)
+		return 0, err
 	}
 
 	slice := strings.Split(string(out), "PkgWatt\n")
@@ -131,12 +156,16 @@ func (fp *FormulaProvider) GetPower(powerchan chan float64) {
 	for _, str := range slice {
 		a = str
 	}
+	fields := strings.Fields(a)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("turbostat: no PkgWatt value in %q", string(out))
+	}
 
-	var b string
-	b = a[0:5]
-	// secondslice := strings.Split(a, " ")
+	return strconv.ParseFloat(fields[0], 64)
+}
 
-	s, err := strconv.ParseFloat(b, 64)
+func (fp *FormulaProvider) GetPower(powerchan chan float64) {
+	s, err := fp.ReadPower()
 	if err != nil {
 		fmt.Println(err)
 	}
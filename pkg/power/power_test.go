@@ -0,0 +1,81 @@
+package power
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Known relationship of the synthetic records: P = a*cpu + b*mem + c.
+const (
+	trueA = 0.42
+	trueB = 0.15
+	trueC = 18.5
+)
+
+// syntheticRecords returns records in the [power, cpu, mem] layout drawn
+// from the known relationship with a little Gaussian noise.
+func syntheticRecords(n int, noise float64) [][]string {
+	rng := rand.New(rand.NewSource(7))
+	records := make([][]string, n)
+	for i := range records {
+		cpu := 100 * rng.Float64()
+		mem := 20 + 60*rng.Float64()
+		p := trueA*cpu + trueB*mem + trueC + noise*rng.NormFloat64()
+		records[i] = []string{
+			strconv.FormatFloat(p, 'f', -1, 64),
+			strconv.FormatFloat(cpu, 'f', -1, 64),
+			strconv.FormatFloat(mem, 'f', -1, 64),
+		}
+	}
+	return records
+}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+func TestRegressionRecoversCoefficients(t *testing.T) {
+	fp := NewFormula()
+	a, b, c := fp.Regression(syntheticRecords(500, 0.05))
+	if !near(a, trueA, 0.005) || !near(b, trueB, 0.005) || !near(c, trueC, 0.2) {
+		t.Errorf("Regression = %.4f*cpu + %.4f*mem + %.4f, want %.4f*cpu + %.4f*mem + %.4f", a, b, c, trueA, trueB, trueC)
+	}
+
+	// The fit itself is kept in normalised units.
+	if fp.Formula.Normalization[FeatureCPU].Max > 100 || fp.Formula.Normalization[FeatureCPU].Min < 0 {
+		t.Errorf("cpu range %v outside the data", fp.Formula.Normalization[FeatureCPU])
+	}
+	if fp.Formula.Coefficients[FeatureCPU] == a {
+		t.Error("normalised and raw cpu coefficients are equal")
+	}
+}
+
+func TestRawWithoutNoise(t *testing.T) {
+	fp := NewFormula()
+	if err := fp.RegressionFeatures(DefaultFeatures, syntheticRecords(50, 0)); err != nil {
+		t.Fatal(err)
+	}
+	coeffs, intercept := fp.Formula.Raw()
+	if !near(coeffs[FeatureCPU], trueA, 1e-9) || !near(coeffs[FeatureMemory], trueB, 1e-9) || !near(intercept, trueC, 1e-9) {
+		t.Errorf("Raw = %v + %.6f, want exact coefficients", coeffs, intercept)
+	}
+}
+
+func TestTrainPredict(t *testing.T) {
+	m, err := Train(syntheticRecords(500, 0.05), DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []struct{ cpu, mem float64 }{{0, 20}, {50, 40}, {100, 80}, {12.5, 63}} {
+		want := trueA*x.cpu + trueB*x.mem + trueC
+		got := m.Predict(map[string]float64{FeatureCPU: x.cpu, FeatureMemory: x.mem})
+		if !near(got, want, 0.1) {
+			t.Errorf("Predict(cpu %.1f, mem %.1f) = %.3f W, want %.3f W", x.cpu, x.mem, got, want)
+		}
+	}
+	if m.R2 < 0.999 {
+		t.Errorf("R2 = %.4f, want close to 1", m.R2)
+	}
+}
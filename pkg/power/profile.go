@@ -0,0 +1,239 @@
+package power
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CPUInfoPath is where DetectHardware reads the processor description.
+const CPUInfoPath = "/proc/cpuinfo"
+
+// Architectures of the built-in profiles, as reported by DetectHardware.
+const (
+	ArchX86   = "x86_64"
+	ArchARM64 = "aarch64"
+	ArchARM   = "arm"
+)
+
+// ErrProfileNotFound is returned for an unknown profile name.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile describes one kind of device the server runs on and the power
+// model trained for it. An empty Model uses the store's active model.
+// IdleWatts is the draw of the quiet device, set by hand or by capturing
+// a Baseline.
+type Profile struct {
+	Name      string    `json:"name"`
+	Arch      string    `json:"arch"`
+	Cores     int       `json:"cores"`
+	IdleWatts float64   `json:"idleWatts"`
+	Model     string    `json:"model,omitempty"`
+	Baseline  *Baseline `json:"baseline,omitempty"`
+}
+
+// Baseline is the state of a quiet device averaged over a capture window.
+// Source tells whether Watts was measured or predicted by the model.
+type Baseline struct {
+	Cpu        float64   `json:"cpu"`
+	Memory     float64   `json:"memory"`
+	Watts      float64   `json:"watts"`
+	Source     string    `json:"source"`
+	Samples    int       `json:"samples"`
+	Window     float64   `json:"window"`
+	CapturedAt time.Time `json:"capturedAt"`
+}
+
+// builtinProfiles seeds a new registry with the two kinds of node the
+// server was written for.
+var builtinProfiles = []Profile{
+	{Name: "host", Arch: ArchX86},
+	{Name: "csd", Arch: ArchARM64},
+}
+
+// Hardware is what DetectHardware finds out about the processor.
+type Hardware struct {
+	Arch      string `json:"arch"`
+	Cores     int    `json:"cores"`
+	ModelName string `json:"modelName,omitempty"`
+}
+
+// DetectHardware reads /proc/cpuinfo.
+func DetectHardware() (Hardware, error) {
+	f, err := os.Open(CPUInfoPath)
+	if err != nil {
+		return Hardware{}, err
+	}
+	defer f.Close()
+	return ParseCPUInfo(f)
+}
+
+// ParseCPUInfo parses the /proc/cpuinfo format. x86 kernels name a
+// vendor_id for every processor while ARM kernels name a CPU architecture.
+func ParseCPUInfo(r io.Reader) (Hardware, error) {
+	hw := Hardware{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "processor":
+			hw.Cores++
+		case "vendor_id":
+			hw.Arch = ArchX86
+		case "CPU architecture":
+			if value == "8" || strings.HasPrefix(value, "AArch64") {
+				hw.Arch = ArchARM64
+			} else if hw.Arch == "" {
+				hw.Arch = ArchARM
+			}
+		case "model name", "Model":
+			if hw.ModelName == "" {
+				hw.ModelName = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return hw, err
+	}
+	if hw.Arch == "" {
+		return hw, errors.New("cpuinfo: unknown architecture")
+	}
+	return hw, nil
+}
+
+// ProfileRegistry keeps the device profiles in a JSON file.
+type ProfileRegistry struct {
+	path string
+
+	mu       sync.RWMutex
+	profiles map[string]Profile
+}
+
+// OpenProfiles loads the registry at path, seeding it with the built-in
+// profiles when the file does not exist yet.
+func OpenProfiles(path string) (*ProfileRegistry, error) {
+	pr := &ProfileRegistry{path: path, profiles: make(map[string]Profile)}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		for _, p := range builtinProfiles {
+			pr.profiles[p.Name] = p
+		}
+		return pr, pr.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Profile
+	if err := json.Unmarshal(contents, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, p := range list {
+		pr.profiles[p.Name] = p
+	}
+	return pr, nil
+}
+
+// save writes the registry. The caller holds pr.mu or owns pr.
+func (pr *ProfileRegistry) save() error {
+	contents, err := json.MarshalIndent(pr.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(pr.path, contents)
+}
+
+func (pr *ProfileRegistry) list() []Profile {
+	list := make([]Profile, 0, len(pr.profiles))
+	for _, p := range pr.profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// List returns every profile sorted by name.
+func (pr *ProfileRegistry) List() []Profile {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.list()
+}
+
+// Get returns one profile.
+func (pr *ProfileRegistry) Get(name string) (Profile, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	p, ok := pr.profiles[name]
+	if !ok {
+		return Profile{}, ErrProfileNotFound
+	}
+	return p, nil
+}
+
+// Put adds or replaces a profile.
+func (pr *ProfileRegistry) Put(p Profile) error {
+	if !datasetName.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q", p.Name)
+	}
+	if p.Cores < 0 || p.IdleWatts < 0 {
+		return errors.New("cores and idle power cannot be negative")
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	old, existed := pr.profiles[p.Name]
+	pr.profiles[p.Name] = p
+	if err := pr.save(); err != nil {
+		if existed {
+			pr.profiles[p.Name] = old
+		} else {
+			delete(pr.profiles, p.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Select returns the named profile, or with an empty name the profile
+// matching the hardware: the same architecture, preferring the same core
+// count. Hardware no profile matches gets an unsaved profile named after
+// its architecture.
+func (pr *ProfileRegistry) Select(name string, hw Hardware) (Profile, error) {
+	if name != "" {
+		return pr.Get(name)
+	}
+
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	var match *Profile
+	for _, p := range pr.list() {
+		p := p
+		if p.Arch != hw.Arch {
+			continue
+		}
+		if match == nil || (p.Cores == hw.Cores && match.Cores != hw.Cores) {
+			match = &p
+		}
+	}
+	if match != nil {
+		return *match, nil
+	}
+	log.Println("profile: no profile for", hw.Arch, "hardware")
+	return Profile{Name: hw.Arch, Arch: hw.Arch, Cores: hw.Cores}, nil
+}
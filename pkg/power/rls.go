@@ -0,0 +1,125 @@
+package power
+
+import "errors"
+
+// MethodRLS marks a model refined online from another one.
+const MethodRLS = "rls"
+
+// DefaultForgetting is the RLS forgetting factor used when none is given.
+// At 0.999 a sample's weight halves after about 700 updates.
+const DefaultForgetting = 0.999
+
+// DefaultCovariance scales the initial RLS covariance. Smaller values trust
+// the starting coefficients more.
+const DefaultCovariance = 1.0
+
+// RLS refines a linear model by recursive least squares with exponential
+// forgetting, one measured sample at a time. It works in the model's
+// normalised space, so the ranges found at training stay fixed.
+type RLS struct {
+	base     *Model
+	features []string
+	lambda   float64
+	theta    []float64
+	p        [][]float64
+	updates  int
+}
+
+// NewRLS starts an updater from the coefficients of m.
+func NewRLS(m *Model, lambda, covariance float64) (*RLS, error) {
+	if lambda <= 0 || lambda > 1 {
+		return nil, errors.New("forgetting factor must be in (0, 1]")
+	}
+	if m.Ensemble != nil {
+		return nil, errors.New("online updates need a linear model")
+	}
+	if covariance <= 0 {
+		return nil, errors.New("initial covariance must be positive")
+	}
+	r := &RLS{
+		base:     m,
+		features: m.Features(),
+		lambda:   lambda,
+	}
+	n := len(r.features) + 1
+	r.theta = make([]float64, n)
+	r.theta[0] = m.Intercept
+	for i, name := range r.features {
+		r.theta[i+1] = m.Coefficients[name]
+	}
+	r.p = make([][]float64, n)
+	for i := range r.p {
+		r.p[i] = make([]float64, n)
+		r.p[i][i] = covariance
+	}
+	return r, nil
+}
+
+// Update folds one sample of features and measured watts into the
+// coefficients and returns the prediction error in watts before the update.
+func (r *RLS) Update(features map[string]float64, watts float64) float64 {
+	n := len(r.theta)
+	x := make([]float64, n)
+	x[0] = 1
+	for i, name := range r.features {
+		x[i+1] = r.base.normalize(name, features[name])
+	}
+	y := r.base.normalize(FeaturePower, watts)
+
+	// px = P x and the gain k = P x / (lambda + x' P x).
+	px := make([]float64, n)
+	for i := range px {
+		for j := range x {
+			px[i] += r.p[i][j] * x[j]
+		}
+	}
+	denom := r.lambda
+	for i := range x {
+		denom += x[i] * px[i]
+	}
+	estimate := 0.0
+	for i := range x {
+		estimate += r.theta[i] * x[i]
+	}
+	e := y - estimate
+	for i := range r.theta {
+		r.theta[i] += px[i] / denom * e
+	}
+	// P = (P - k x' P) / lambda; P is symmetric so x' P = px'.
+	for i := range r.p {
+		for j := range r.p[i] {
+			r.p[i][j] = (r.p[i][j] - px[i]*px[j]/denom) / r.lambda
+		}
+	}
+	r.updates++
+
+	return watts - r.base.denormalize(FeaturePower, estimate)
+}
+
+// Updates returns the number of samples folded in since NewRLS.
+func (r *RLS) Updates() int {
+	return r.updates
+}
+
+// Model returns a copy of the starting model with the refined
+// coefficients. The evaluation and uncertainty of the training fit do not
+// hold for them and are left out.
+func (r *RLS) Model() *Model {
+	m := *r.base
+	m.Evaluation = nil
+	m.Uncertainty = nil
+	m.Intercept = r.theta[0]
+	m.Coefficients = make(map[string]float64, len(r.features))
+	for i, name := range r.features {
+		m.Coefficients[name] = r.theta[i+1]
+	}
+	m.Updates = r.base.Updates + r.updates
+	return &m
+}
+
+// Rebase makes m, a saved copy of Model(), the starting point of later
+// copies while keeping the coefficients and covariance reached so far.
+func (r *RLS) Rebase(m *Model) {
+	r.base = m
+	r.updates = 0
+}
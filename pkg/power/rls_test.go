@@ -0,0 +1,116 @@
+package power
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// wrongModel returns a model trained on the synthetic records with its
+// normalised coefficients then knocked off.
+func wrongModel(t *testing.T) *Model {
+	t.Helper()
+	m, err := Train(syntheticRecords(200, 0.05), DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Intercept += 0.3
+	m.Coefficients[FeatureCPU] *= 0.5
+	m.Coefficients[FeatureMemory] *= 2
+	return m
+}
+
+// feed updates r with n samples of P = a*cpu + trueB*mem + c plus noise.
+func feed(r *RLS, rng *rand.Rand, n int, a, c float64) {
+	for i := 0; i < n; i++ {
+		cpu := 100 * rng.Float64()
+		mem := 20 + 60*rng.Float64()
+		r.Update(map[string]float64{FeatureCPU: cpu, FeatureMemory: mem}, a*cpu+trueB*mem+c+0.2*rng.NormFloat64())
+	}
+}
+
+// worstError returns the largest prediction error of m against
+// P = a*cpu + trueB*mem + c over a grid of inputs.
+func worstError(m *Model, a, c float64) float64 {
+	worst := 0.0
+	for cpu := 0.0; cpu <= 100; cpu += 25 {
+		for mem := 20.0; mem <= 80; mem += 20 {
+			got := m.Predict(map[string]float64{FeatureCPU: cpu, FeatureMemory: mem})
+			if e := got - (a*cpu + trueB*mem + c); e > worst {
+				worst = e
+			} else if -e > worst {
+				worst = -e
+			}
+		}
+	}
+	return worst
+}
+
+func TestRLSConverges(t *testing.T) {
+	start := wrongModel(t)
+	if e := worstError(start, trueA, trueC); e < 5 {
+		t.Fatalf("starting model is only %.2f W off; the test needs a wrong one", e)
+	}
+	r, err := NewRLS(start, DefaultForgetting, DefaultCovariance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed(r, rand.New(rand.NewSource(1)), 500, trueA, trueC)
+
+	m := r.Model()
+	if e := worstError(m, trueA, trueC); e > 0.3 {
+		t.Errorf("after 500 updates the model is up to %.3f W off", e)
+	}
+	if m.Updates != 500 || r.Updates() != 500 {
+		t.Errorf("updates = %d and %d, want 500", m.Updates, r.Updates())
+	}
+	// The training fit's evaluation and uncertainty describe the old
+	// coefficients.
+	if start.Evaluation == nil || start.Uncertainty == nil {
+		t.Fatal("trained model has no evaluation or uncertainty to drop")
+	}
+	if m.Evaluation != nil || m.Uncertainty != nil {
+		t.Error("refined model kept the evaluation and uncertainty of the training fit")
+	}
+}
+
+func TestRLSForgetting(t *testing.T) {
+	// After a long run of the trained relationship the machine starts
+	// drawing 6 W more. Forgetting lets the updater follow it; without
+	// forgetting the old samples hold it back.
+	offBy := make(map[float64]float64)
+	for _, lambda := range []float64{1, 0.98} {
+		m, err := Train(syntheticRecords(200, 0.05), DefaultFeatures, TrainOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewRLS(m, lambda, DefaultCovariance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rng := rand.New(rand.NewSource(2))
+		feed(r, rng, 2000, trueA, trueC)
+		feed(r, rng, 300, trueA, trueC+6)
+		offBy[lambda] = worstError(r.Model(), trueA, trueC+6)
+	}
+	if offBy[0.98] > 0.3 {
+		t.Errorf("with forgetting the model is up to %.3f W off the new relationship", offBy[0.98])
+	}
+	if offBy[1] < 1 {
+		t.Errorf("without forgetting the model is only %.3f W off; it should lag the change", offBy[1])
+	}
+}
+
+func TestNewRLSRejects(t *testing.T) {
+	m, err := Train(syntheticRecords(50, 0.05), DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lambda := range []float64{0, -0.5, 1.01} {
+		if _, err := NewRLS(m, lambda, DefaultCovariance); err == nil {
+			t.Errorf("NewRLS accepted forgetting factor %g", lambda)
+		}
+	}
+	if _, err := NewRLS(m, DefaultForgetting, 0); err == nil {
+		t.Error("NewRLS accepted a zero covariance")
+	}
+}
@@ -0,0 +1,349 @@
+package power
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Fitting methods. OLS is the plain least squares fit of Regression; the
+// others limit the influence of outlying rows such as turbostat spikes.
+const (
+	MethodOLS    = "ols"
+	MethodHuber  = "huber"
+	MethodRANSAC = "ransac"
+)
+
+// Defaults for FitOptions.
+const (
+	DefaultHuberK           = 1.345
+	DefaultRANSACIterations = 200
+)
+
+const (
+	huberMaxIterations = 50
+	huberTolerance     = 1e-9
+
+	// ransacScale is the default inlier threshold in robust standard
+	// deviations of the least squares residuals.
+	ransacScale = 2.5
+
+	// huberOutlierFactor is how many times HuberK a residual has to reach
+	// before a Huber fit reports its row. Huber down-weights about one
+	// row in five of clean Gaussian data, which are no outliers.
+	huberOutlierFactor = 2.5
+)
+
+// Model types.
+const (
+	TypeLinear = "linear"
+	TypeGBT    = "gbt"
+)
+
+// FitOptions selects how a model is fitted.
+type FitOptions struct {
+	// Type is TypeLinear or TypeGBT; linear when empty. Method and the
+	// Huber and RANSAC settings apply to linear models, the tree settings
+	// to gradient-boosted ones.
+	Type string `json:"type,omitempty"`
+
+	// Method is MethodOLS, MethodHuber or MethodRANSAC; OLS when empty.
+	Method string `json:"method,omitempty"`
+
+	// HuberK is the Huber threshold in robust standard deviations of the
+	// residuals. Rows beyond it are down-weighted.
+	HuberK float64 `json:"huberK,omitempty"`
+
+	// RANSACIterations is the number of random minimal fits tried.
+	RANSACIterations int `json:"ransacIterations,omitempty"`
+
+	// RANSACThreshold is the largest residual in watts of an inlier. By
+	// default it is 2.5 robust standard deviations of the OLS residuals.
+	RANSACThreshold float64 `json:"ransacThreshold,omitempty"`
+
+	// Trees is the number of boosting rounds, Depth the depth of every
+	// tree, LearningRate the shrinkage of each tree's output and MinLeaf
+	// the fewest records a leaf may hold.
+	Trees        int     `json:"trees,omitempty"`
+	Depth        int     `json:"depth,omitempty"`
+	LearningRate float64 `json:"learningRate,omitempty"`
+	MinLeaf      int     `json:"minLeaf,omitempty"`
+}
+
+func (o FitOptions) withDefaults() (FitOptions, error) {
+	switch o.Type {
+	case "":
+		o.Type = TypeLinear
+	case TypeLinear:
+	case TypeGBT:
+		if o.Method != "" {
+			return o, fmt.Errorf("fit method %q applies to linear models only", o.Method)
+		}
+		if o.Trees <= 0 {
+			o.Trees = DefaultTrees
+		}
+		if o.Depth <= 0 {
+			o.Depth = DefaultDepth
+		}
+		if o.LearningRate <= 0 || o.LearningRate > 1 {
+			o.LearningRate = DefaultLearningRate
+		}
+		if o.MinLeaf <= 0 {
+			o.MinLeaf = DefaultMinLeaf
+		}
+		return o, nil
+	default:
+		return o, fmt.Errorf("unknown model type %q", o.Type)
+	}
+
+	switch o.Method {
+	case "":
+		o.Method = MethodOLS
+	case MethodOLS, MethodHuber, MethodRANSAC:
+	default:
+		return o, fmt.Errorf("unknown fit method %q", o.Method)
+	}
+	if o.HuberK <= 0 {
+		o.HuberK = DefaultHuberK
+	}
+	if o.RANSACIterations <= 0 {
+		o.RANSACIterations = DefaultRANSACIterations
+	}
+	return o, nil
+}
+
+// Outlier is a training record a robust fit found far off the model.
+// Record counts from 1 and Residual is in watts.
+type Outlier struct {
+	Record   int     `json:"record"`
+	Residual float64 `json:"residual"`
+	Weight   float64 `json:"weight"`
+	Rejected bool    `json:"rejected"`
+}
+
+// robustFit fits the records with the Huber or RANSAC method of opts. It
+// returns the normalised intercept and coefficients, the in-sample R² and
+// the outlying rows: those RANSAC rejected, or those whose Huber residual
+// is beyond huberOutlierFactor times HuberK robust standard deviations.
+func robustFit(features []string, records [][]string, opts FitOptions) (*Model, []Outlier, error) {
+	data, err := normalizeRecords(features, records)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var beta, weights []float64
+	switch opts.Method {
+	case MethodHuber:
+		beta, weights, err = huberFit(data, opts.HuberK)
+	case MethodRANSAC:
+		power := data.ranges[FeaturePower]
+		beta, weights, err = ransacFit(data, opts.RANSACThreshold/(power.Max-power.Min), opts.RANSACIterations)
+	default:
+		err = fmt.Errorf("unknown fit method %q", opts.Method)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := &Model{
+		Intercept:     beta[0],
+		Coefficients:  make(map[string]float64, len(features)),
+		Normalization: data.ranges,
+		Observations:  len(records),
+	}
+	for i, name := range features {
+		m.Coefficients[name] = beta[i+1]
+	}
+	m.Uncertainty = newUncertainty(data, features, beta, weights)
+
+	res := residuals(data, beta)
+	predicted := make([]float64, len(res))
+	for i := range res {
+		predicted[i] = data.y[i] - res[i]
+	}
+	m.R2 = errorMetrics(data.y, predicted, len(features)).R2
+
+	powerRange := data.ranges[FeaturePower].Max - data.ranges[FeaturePower].Min
+	cutoff := huberOutlierFactor * opts.HuberK * madScale(res)
+	var outliers []Outlier
+	for i, w := range weights {
+		if w > 0 && math.Abs(res[i]) <= cutoff {
+			continue
+		}
+		if opts.Method == MethodRANSAC && w > 0 {
+			continue
+		}
+		outliers = append(outliers, Outlier{
+			Record:   i + 1,
+			Residual: res[i] * powerRange,
+			Weight:   w,
+			Rejected: w == 0,
+		})
+	}
+	return m, outliers, nil
+}
+
+// huberFit minimises the Huber loss by iteratively reweighted least
+// squares, starting from the ordinary fit.
+func huberFit(data *normalized, k float64) (beta, weights []float64, err error) {
+	weights = make([]float64, len(data.y))
+	for i := range weights {
+		weights[i] = 1
+	}
+	beta, err = weightedLeastSquares(data.x, data.y, weights)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for iter := 0; iter < huberMaxIterations; iter++ {
+		res := residuals(data, beta)
+		scale := madScale(res)
+		if scale == 0 {
+			break
+		}
+		for i, r := range res {
+			u := math.Abs(r) / scale
+			weights[i] = 1
+			if u > k {
+				weights[i] = k / u
+			}
+		}
+		next, err := weightedLeastSquares(data.x, data.y, weights)
+		if err != nil {
+			return nil, nil, err
+		}
+		change := 0.0
+		for i := range next {
+			change = math.Max(change, math.Abs(next[i]-beta[i]))
+		}
+		beta = next
+		if change < huberTolerance {
+			break
+		}
+	}
+	return beta, weights, nil
+}
+
+// ransacFit fits minimal random subsets, keeps the one with the most rows
+// within threshold of it and refits on those rows. A zero threshold is
+// derived from the ordinary fit. Rejected rows get weight 0.
+func ransacFit(data *normalized, threshold float64, iterations int) (beta, weights []float64, err error) {
+	n := len(data.y)
+	size := len(data.x[0]) + 1
+	if n <= size {
+		return nil, nil, fmt.Errorf("RANSAC needs more than %d records", size)
+	}
+	ones := make([]float64, n)
+	for i := range ones {
+		ones[i] = 1
+	}
+	if threshold <= 0 {
+		ols, err := weightedLeastSquares(data.x, data.y, ones)
+		if err != nil {
+			return nil, nil, err
+		}
+		threshold = math.Max(ransacScale*madScale(residuals(data, ols)), 1e-9)
+	}
+
+	rng := rand.New(rand.NewSource(evalSeed))
+	var best []bool
+	bestCount, bestSSE := 0, math.Inf(1)
+	for iter := 0; iter < iterations; iter++ {
+		sample := make([]float64, n)
+		for _, i := range rng.Perm(n)[:size] {
+			sample[i] = 1
+		}
+		candidate, err := weightedLeastSquares(data.x, data.y, sample)
+		if err != nil {
+			continue
+		}
+		inliers := make([]bool, n)
+		count, sse := 0, 0.0
+		for i, r := range residuals(data, candidate) {
+			if math.Abs(r) <= threshold {
+				inliers[i] = true
+				count++
+				sse += r * r
+			}
+		}
+		if count > bestCount || (count == bestCount && sse < bestSSE) {
+			best, bestCount, bestSSE = inliers, count, sse
+		}
+	}
+	if bestCount < size {
+		return nil, nil, errors.New("RANSAC found no consensus set")
+	}
+
+	weights = make([]float64, n)
+	for i, in := range best {
+		if in {
+			weights[i] = 1
+		}
+	}
+	beta, err = weightedLeastSquares(data.x, data.y, weights)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beta, weights, nil
+}
+
+// weightedLeastSquares solves for the intercept and coefficients that
+// minimise the weighted squared residuals.
+func weightedLeastSquares(x [][]float64, y, weights []float64) ([]float64, error) {
+	n, p := len(y), len(x[0])+1
+	a := mat.NewDense(n, p, nil)
+	b := mat.NewVecDense(n, nil)
+	for i := range y {
+		w := math.Sqrt(weights[i])
+		a.Set(i, 0, w)
+		for j, v := range x[i] {
+			a.Set(i, j+1, w*v)
+		}
+		b.SetVec(i, w*y[i])
+	}
+	var beta mat.VecDense
+	if err := beta.SolveVec(a, b); err != nil {
+		return nil, err
+	}
+	return beta.RawVector().Data, nil
+}
+
+func residuals(data *normalized, beta []float64) []float64 {
+	res := make([]float64, len(data.y))
+	for i, row := range data.x {
+		p := beta[0]
+		for j, v := range row {
+			p += beta[j+1] * v
+		}
+		res[i] = data.y[i] - p
+	}
+	return res
+}
+
+// madScale estimates the standard deviation of residuals from their
+// median absolute deviation, which outliers barely move.
+func madScale(res []float64) float64 {
+	if len(res) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), res...)
+	sort.Float64s(sorted)
+	median := medianOf(sorted)
+	for i, r := range res {
+		sorted[i] = math.Abs(r - median)
+	}
+	sort.Float64s(sorted)
+	return medianOf(sorted) / 0.6745
+}
+
+func medianOf(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
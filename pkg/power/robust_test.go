@@ -0,0 +1,60 @@
+package power
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// spikedRecords returns synthetic records with 30 W added to the power of
+// a few rows, and the 1-based record numbers of those rows.
+func spikedRecords() ([][]string, []int) {
+	records := syntheticRecords(300, 0.5)
+	spiked := []int{17, 90, 151, 222, 300}
+	for _, n := range spiked {
+		p, _ := strconv.ParseFloat(records[n-1][0], 64)
+		records[n-1][0] = strconv.FormatFloat(p+30, 'f', -1, 64)
+	}
+	return records, spiked
+}
+
+func TestRobustFitsIgnoreSpikes(t *testing.T) {
+	records, spiked := spikedRecords()
+	points := []struct{ cpu, mem float64 }{{0, 20}, {50, 40}, {100, 80}, {12.5, 63}}
+
+	ols, err := Train(records, DefaultFeatures, TrainOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ols.Predict(map[string]float64{FeatureCPU: 0, FeatureMemory: 20}); near(got, trueB*20+trueC, 0.3) {
+		t.Fatalf("least squares predicts %.3f W at idle despite the spikes; the test data is too easy", got)
+	}
+	if len(ols.Evaluation.Outliers) != 0 {
+		t.Errorf("least squares reported outliers %+v", ols.Evaluation.Outliers)
+	}
+
+	for _, method := range []string{MethodHuber, MethodRANSAC} {
+		m, err := Train(records, DefaultFeatures, TrainOptions{FitOptions: FitOptions{Method: method}})
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		for _, x := range points {
+			want := trueA*x.cpu + trueB*x.mem + trueC
+			got := m.Predict(map[string]float64{FeatureCPU: x.cpu, FeatureMemory: x.mem})
+			if !near(got, want, 0.3) {
+				t.Errorf("%s: Predict(cpu %.1f, mem %.1f) = %.3f W, want %.3f W", method, x.cpu, x.mem, got, want)
+			}
+		}
+
+		var flagged []int
+		for _, o := range m.Evaluation.Outliers {
+			flagged = append(flagged, o.Record)
+			if !near(o.Residual, 30, 1.5) {
+				t.Errorf("%s: record %d has residual %.2f W, want about 30 W", method, o.Record, o.Residual)
+			}
+		}
+		if !reflect.DeepEqual(flagged, spiked) {
+			t.Errorf("%s: flagged records %v, want the spiked %v", method, flagged, spiked)
+		}
+	}
+}
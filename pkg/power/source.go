@@ -0,0 +1,201 @@
+package power
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the power sources accepted by Open.
+const (
+	SourceAuto      = "auto"
+	SourceRAPL      = "rapl"
+	SourceTurbostat = "turbostat"
+	SourceModel     = "model"
+)
+
+// DefaultPowercapRoot is where the kernel exposes the powercap framework.
+const DefaultPowercapRoot = "/sys/class/powercap"
+
+// turbostatProbe is how long SourceAuto waits for the first turbostat
+// reading, which comes one interval after the start.
+const turbostatProbe = 3 * time.Second
+
+// ErrNotMeasured is returned by sources that cannot measure power and leave
+// the estimate to the regression model.
+var ErrNotMeasured = errors.New("power source does not measure power")
+
+// PowerSource reports the power draw of the node. Read reports the average
+// since the previous call, so a source has a single reader that hands the
+// readings out.
+type PowerSource interface {
+	// Name identifies the backend, one of the Source constants.
+	Name() string
+	// Measured is false for sources that only have the regression model.
+	Measured() bool
+	// Read returns the power in watts since the previous Read.
+	Read() (float64, error)
+	// Close releases the resources held by the source.
+	Close() error
+}
+
+// DetailSource is implemented by sources that report more than the package
+// power, such as the per-domain watts and frequency columns of turbostat.
+type DetailSource interface {
+	Detail() map[string]float64
+}
+
+// Open creates the named power source. SourceAuto picks RAPL when the
+// powercap tree is readable, then turbostat when it is installed and
+// produces a reading, and falls back to the model.
+func Open(name, powercapRoot string) (PowerSource, error) {
+	if powercapRoot == "" {
+		powercapRoot = DefaultPowercapRoot
+	}
+
+	switch name {
+	case SourceRAPL:
+		return NewRAPLSource(powercapRoot)
+	case SourceTurbostat:
+		return NewTurbostatSource(time.Second)
+	case SourceModel:
+		return NewModelSource(), nil
+	case SourceAuto, "":
+		if src, err := NewRAPLSource(powercapRoot); err == nil {
+			return src, nil
+		}
+		if _, err := exec.LookPath("turbostat"); err == nil {
+			src, err := NewTurbostatSource(time.Second)
+			if err == nil {
+				if err = src.WaitReading(turbostatProbe); err == nil {
+					return src, nil
+				}
+				src.Close()
+			}
+			log.Println("turbostat unusable, using the model:", err)
+		}
+		return NewModelSource(), nil
+	}
+	return nil, fmt.Errorf("unknown power source %q", name)
+}
+
+// raplZone matches the package level zones, intel-rapl:0, intel-rapl:1 and
+// so on. Their subzones (core, uncore, dram) are already included in the
+// package counter and are skipped to avoid counting them twice.
+var raplZone = regexp.MustCompile(`^intel-rapl:[0-9]+$`)
+
+type raplDomain struct {
+	path     string
+	maxRange uint64
+	last     uint64
+}
+
+// RAPLSource reads the Intel RAPL energy counters from the powercap sysfs
+// tree. The root is configurable so it can point at a fake tree.
+type RAPLSource struct {
+	mu      sync.Mutex
+	domains []*raplDomain
+	at      time.Time
+	watts   float64
+}
+
+// NewRAPLSource finds the package zones under root and takes the first
+// counter readings.
+func NewRAPLSource(root string) (*RAPLSource, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &RAPLSource{}
+	for _, entry := range entries {
+		if !raplZone.MatchString(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		d := &raplDomain{path: filepath.Join(dir, "energy_uj")}
+		if d.last, err = readUint(d.path); err != nil {
+			return nil, err
+		}
+		if d.maxRange, err = readUint(filepath.Join(dir, "max_energy_range_uj")); err != nil {
+			return nil, err
+		}
+		src.domains = append(src.domains, d)
+	}
+	if len(src.domains) == 0 {
+		return nil, fmt.Errorf("no RAPL zones under %s", root)
+	}
+	src.at = time.Now()
+
+	return src, nil
+}
+
+func readUint(path string) (uint64, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+}
+
+// counterDelta returns how far an energy counter advanced, allowing for one
+// wraparound at maxRange.
+func counterDelta(last, now, maxRange uint64) uint64 {
+	if now >= last {
+		return now - last
+	}
+	return maxRange - last + now
+}
+
+func (r *RAPLSource) Name() string   { return SourceRAPL }
+func (r *RAPLSource) Measured() bool { return true }
+func (r *RAPLSource) Close() error   { return nil }
+
+// Read sums the package counters and divides by the time since the previous
+// Read. Called again within the same instant it repeats the last value.
+func (r *RAPLSource) Read() (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.at).Seconds()
+	if elapsed <= 0 {
+		return r.watts, nil
+	}
+
+	var microjoules uint64
+	for _, d := range r.domains {
+		energy, err := readUint(d.path)
+		if err != nil {
+			return 0, err
+		}
+		microjoules += counterDelta(d.last, energy, d.maxRange)
+		d.last = energy
+	}
+	r.at = now
+	r.watts = float64(microjoules) / 1e6 / elapsed
+
+	return r.watts, nil
+}
+
+// ModelSource is the source of nodes without a power meter, such as the
+// CSD. Energy there comes from the regression model alone.
+type ModelSource struct{}
+
+// NewModelSource returns the model-only source.
+func NewModelSource() *ModelSource {
+	return &ModelSource{}
+}
+
+func (m *ModelSource) Name() string           { return SourceModel }
+func (m *ModelSource) Measured() bool         { return false }
+func (m *ModelSource) Read() (float64, error) { return 0, ErrNotMeasured }
+func (m *ModelSource) Close() error           { return nil }
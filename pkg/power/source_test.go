@@ -0,0 +1,111 @@
+package power
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeZone creates one powercap zone directory with its counters.
+func writeZone(t *testing.T, root, name string, energy, maxRange uint64) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	setEnergy(t, root, name, energy)
+	if err := ioutil.WriteFile(filepath.Join(dir, "max_energy_range_uj"), []byte(strconv.FormatUint(maxRange, 10)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setEnergy(t *testing.T, root, name string, energy uint64) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(root, name, "energy_uj"), []byte(strconv.FormatUint(energy, 10)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		last, now, maxRange, want uint64
+	}{
+		{100, 250, 1000, 150},
+		{100, 100, 1000, 0},
+		{900, 50, 1000, 150},
+		{999, 0, 1000, 1},
+	}
+	for _, tt := range tests {
+		if got := counterDelta(tt.last, tt.now, tt.maxRange); got != tt.want {
+			t.Errorf("counterDelta(%d, %d, %d) = %d, want %d", tt.last, tt.now, tt.maxRange, got, tt.want)
+		}
+	}
+}
+
+func TestRAPLSource(t *testing.T) {
+	root := t.TempDir()
+	const maxRange = 262143328850
+	// The control type directory and the subzones of package 0 must not
+	// be read as packages.
+	if err := os.MkdirAll(filepath.Join(root, "intel-rapl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeZone(t, root, "intel-rapl:0", 1000000, maxRange)
+	writeZone(t, root, "intel-rapl:0:0", 500000, maxRange)
+	writeZone(t, root, "intel-rapl:0:1", 200000, maxRange)
+	writeZone(t, root, "intel-rapl:1", maxRange-1000000, maxRange)
+
+	src, err := NewRAPLSource(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.domains) != 2 {
+		t.Fatalf("found %d package zones, want 2", len(src.domains))
+	}
+
+	// Package 0 advances by 6 J and package 1 wraps around after 2 J,
+	// over two seconds. The subzones advance too but must not count.
+	setEnergy(t, root, "intel-rapl:0", 7000000)
+	setEnergy(t, root, "intel-rapl:0:0", 9000000)
+	setEnergy(t, root, "intel-rapl:0:1", 9000000)
+	setEnergy(t, root, "intel-rapl:1", 1000000)
+	src.at = time.Now().Add(-2 * time.Second)
+
+	watts, err := src.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4.0; math.Abs(watts-want) > 0.01 {
+		t.Errorf("Read = %.3f W, want %.3f W", watts, want)
+	}
+}
+
+func TestRAPLSourceNoZones(t *testing.T) {
+	if _, err := NewRAPLSource(t.TempDir()); err == nil {
+		t.Error("NewRAPLSource found zones in an empty tree")
+	}
+}
+
+func TestOpenAutoSkipsFailingTurbostat(t *testing.T) {
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho 'turbostat: must be root' >&2\nexit 1\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "turbostat"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", bin)
+
+	src, err := Open(SourceAuto, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if src.Name() != SourceModel {
+		t.Errorf("Open(auto) chose %s, want %s", src.Name(), SourceModel)
+	}
+}
@@ -0,0 +1,277 @@
+package power
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TurbostatColumns are the turbostat columns the stream asks for.
+var TurbostatColumns = []string{"PkgWatt", "CorWatt", "RAMWatt", "GFXWatt", "Busy%", "Bzy_MHz", "PkgTmp"}
+
+// TurbostatReading is one summary row of turbostat output. Columns the
+// machine does not report, such as GFXWatt on servers, are left out of
+// Columns and read as zero.
+type TurbostatReading struct {
+	Time    time.Time          `json:"time"`
+	Columns map[string]float64 `json:"columns"`
+}
+
+func (r TurbostatReading) PkgWatt() float64 { return r.Columns["PkgWatt"] }
+func (r TurbostatReading) CorWatt() float64 { return r.Columns["CorWatt"] }
+func (r TurbostatReading) RAMWatt() float64 { return r.Columns["RAMWatt"] }
+func (r TurbostatReading) GFXWatt() float64 { return r.Columns["GFXWatt"] }
+func (r TurbostatReading) Busy() float64    { return r.Columns["Busy%"] }
+func (r TurbostatReading) BzyMHz() float64  { return r.Columns["Bzy_MHz"] }
+func (r TurbostatReading) PkgTmp() float64  { return r.Columns["PkgTmp"] }
+
+// TurbostatParser turns turbostat's tabular output into readings, one line
+// at a time. A line is taken as a header when it names a known column, so
+// headers repeated between intervals are picked up again. Rows are matched
+// to columns by header name, never by position in the line.
+type TurbostatParser struct {
+	header []string
+
+	// Skipped counts the data lines that could not be parsed.
+	Skipped int
+}
+
+func isTurbostatHeader(fields []string) bool {
+	for _, field := range fields {
+		for _, col := range TurbostatColumns {
+			if field == col {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseLine parses one line of output. It reports ok only for a data line
+// that yielded a reading.
+func (p *TurbostatParser) ParseLine(line string) (reading TurbostatReading, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return reading, false
+	}
+	if isTurbostatHeader(fields) {
+		p.header = fields
+		return reading, false
+	}
+	if p.header == nil || len(fields) != len(p.header) {
+		p.Skipped++
+		return reading, false
+	}
+
+	// Without --Summary the first row is the package summary and per-CPU
+	// rows follow. Those carry a CPU number rather than "-".
+	for i, name := range p.header {
+		if (name == "CPU" || name == "Core") && fields[i] != "-" {
+			return reading, false
+		}
+	}
+
+	reading.Columns = make(map[string]float64)
+	for i, name := range p.header {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			continue
+		}
+		reading.Columns[name] = v
+	}
+	if len(reading.Columns) == 0 {
+		p.Skipped++
+		return reading, false
+	}
+	reading.Time = time.Now()
+
+	return reading, true
+}
+
+// ParseTurbostat reads turbostat output until r ends and hands every
+// reading to fn.
+func ParseTurbostat(r io.Reader, fn func(TurbostatReading)) error {
+	p := &TurbostatParser{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if reading, ok := p.ParseLine(scanner.Text()); ok {
+			fn(reading)
+		}
+	}
+	return scanner.Err()
+}
+
+// TurbostatSource keeps one turbostat child process running for its whole
+// life and parses its output as it arrives. The child is restarted with a
+// backoff when it exits.
+type TurbostatSource struct {
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	latest TurbostatReading
+
+	// exits counts how often the child ended and exitErr says why it
+	// last did.
+	exits   int
+	exitErr error
+}
+
+// NewTurbostatSource starts turbostat reporting every interval.
+func NewTurbostatSource(interval time.Duration) (*TurbostatSource, error) {
+	if _, err := exec.LookPath("turbostat"); err != nil {
+		return nil, err
+	}
+	t := &TurbostatSource{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.supervise()
+
+	return t, nil
+}
+
+func (t *TurbostatSource) supervise() {
+	defer close(t.done)
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := t.runOnce()
+		t.mu.Lock()
+		t.exits++
+		t.exitErr = err
+		t.mu.Unlock()
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+		log.Println("turbostat exited, restarting in", backoff, err)
+
+		// A child that ran for a while gets a fresh backoff.
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (t *TurbostatSource) runOnce() error {
+	cmd := exec.Command("turbostat", "--quiet", "--Summary",
+		"--interval", strconv.FormatFloat(t.interval.Seconds(), 'f', -1, 64),
+		"--show", strings.Join(TurbostatColumns, ","))
+	cmd.Stderr = ioutil.Discard
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.cmd = cmd
+	select {
+	case <-t.stop:
+		// Close ran while the child was starting.
+		cmd.Process.Kill()
+	default:
+	}
+	t.mu.Unlock()
+
+	err = ParseTurbostat(stdout, func(reading TurbostatReading) {
+		t.mu.Lock()
+		t.latest = reading
+		t.mu.Unlock()
+	})
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+func (t *TurbostatSource) Name() string   { return SourceTurbostat }
+func (t *TurbostatSource) Measured() bool { return true }
+
+// Latest returns the most recent reading.
+func (t *TurbostatSource) Latest() TurbostatReading {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest
+}
+
+// WaitReading blocks until turbostat has produced its first reading. It
+// fails when the child exits before that, as it does without the rights
+// to read the MSRs, or when no reading arrives within timeout.
+func (t *TurbostatSource) WaitReading(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		t.mu.Lock()
+		ready := !t.latest.Time.IsZero()
+		exits, exitErr := t.exits, t.exitErr
+		t.mu.Unlock()
+		if ready {
+			return nil
+		}
+		if exits > 0 {
+			return fmt.Errorf("turbostat exited before its first reading: %v", exitErr)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("turbostat: no reading within %s", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Read returns the PkgWatt of the latest interval. A reading older than a
+// few intervals means turbostat stalled or is restarting.
+func (t *TurbostatSource) Read() (float64, error) {
+	latest := t.Latest()
+	if latest.Time.IsZero() {
+		return 0, errors.New("turbostat: no reading yet")
+	}
+	if age := time.Since(latest.Time); age > 5*t.interval {
+		return latest.PkgWatt(), fmt.Errorf("turbostat: last reading is %s old", age.Round(time.Millisecond))
+	}
+	if _, ok := latest.Columns["PkgWatt"]; !ok {
+		return 0, errors.New("turbostat: PkgWatt not reported")
+	}
+	return latest.PkgWatt(), nil
+}
+
+// Detail returns every column of the latest reading.
+func (t *TurbostatSource) Detail() map[string]float64 {
+	return t.Latest().Columns
+}
+
+// Close stops turbostat and waits for the supervisor to exit. Closing
+// again does nothing.
+func (t *TurbostatSource) Close() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.mu.Lock()
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	t.mu.Unlock()
+	<-t.done
+	return nil
+}
@@ -0,0 +1,111 @@
+package power
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTurbostatFixture(t *testing.T) {
+	f, err := os.Open("testdata/turbostat.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var readings []TurbostatReading
+	if err := ParseTurbostat(f, func(r TurbostatReading) { readings = append(readings, r) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The truncated line is dropped, and after the restart the columns
+	// come in another order without GFXWatt.
+	want := []struct {
+		pkg, cor, ram, gfx, busy, mhz, tmp float64
+	}{
+		{12.34, 6.10, 1.50, 0.02, 3.21, 2893, 45},
+		{38.90, 31.25, 2.11, 0, 41.77, 3392, 58},
+		{8.02, 1.95, 1.43, 0, 0.88, 1204, 41},
+		{64.50, 55.80, 3.02, 0, 99.10, 3600, 71},
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("got %d readings, want %d", len(readings), len(want))
+	}
+	for i, w := range want {
+		r := readings[i]
+		got := [...]float64{r.PkgWatt(), r.CorWatt(), r.RAMWatt(), r.GFXWatt(), r.Busy(), r.BzyMHz(), r.PkgTmp()}
+		exp := [...]float64{w.pkg, w.cor, w.ram, w.gfx, w.busy, w.mhz, w.tmp}
+		if got != exp {
+			t.Errorf("reading %d = %v, want %v", i, got, exp)
+		}
+		if r.Time.IsZero() {
+			t.Errorf("reading %d has no time", i)
+		}
+	}
+	if _, ok := readings[2].Columns["GFXWatt"]; ok {
+		t.Error("GFXWatt reported after the restart")
+	}
+}
+
+func TestTurbostatParserSkipped(t *testing.T) {
+	p := &TurbostatParser{}
+	lines := []string{
+		"3.21\t2893",
+		"Busy%\tPkgWatt",
+		"27.0",
+		"n/a\tn/a",
+		"12.5\t20.25",
+	}
+	readings := 0
+	for _, line := range lines {
+		if _, ok := p.ParseLine(line); ok {
+			readings++
+		}
+	}
+	if readings != 1 {
+		t.Errorf("got %d readings, want 1", readings)
+	}
+	// A row before the first header, a short row and a row without a
+	// number.
+	if p.Skipped != 3 {
+		t.Errorf("Skipped = %d, want 3", p.Skipped)
+	}
+}
+
+func TestTurbostatParserPerCPU(t *testing.T) {
+	out := "Core\tCPU\tBusy%\tPkgWatt\n-\t-\t10.00\t30.00\n0\t0\t12.00\t30.00\n1\t1\t8.00\t\n"
+	var readings []TurbostatReading
+	if err := ParseTurbostat(strings.NewReader(out), func(r TurbostatReading) { readings = append(readings, r) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Busy() != 10 {
+		t.Errorf("got %v, want the summary row only", readings)
+	}
+}
+
+func TestTurbostatSourceClose(t *testing.T) {
+	bin := t.TempDir()
+	script := "#!/bin/sh\nprintf 'Busy%%\\tPkgWatt\\n'\nwhile :; do printf '12.5\\t20.25\\n'; sleep 0.1; done\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "turbostat"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+
+	src, err := NewTurbostatSource(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WaitReading(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if watts, err := src.Read(); err != nil || watts != 20.25 {
+		t.Errorf("Read = %v, %v, want 20.25 W", watts, err)
+	}
+	src.Close()
+	src.Close()
+}
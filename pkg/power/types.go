@@ -21,4 +21,27 @@ type formula struct {
 	delta      float64
 	Intercept  float64
 	Regression regression.Regression
+
+	// Features names the regression variables in order and Coefficients
+	// holds their normalised coefficients. Alpha, Beta, gamma and delta
+	// mirror the first four.
+	Features     []string
+	Coefficients map[string]float64
+
+	// Normalization holds the min-max range of every column the regression
+	// was trained on, keyed by feature name.
+	Normalization map[string]Range
+}
+
+// Range is the min-max interval a column was normalised with.
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r Range) normalize(v float64) float64 {
+	if r.Max == r.Min {
+		return v
+	}
+	return (v - r.Min) / (r.Max - r.Min)
 }
@@ -0,0 +1,196 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// attributor splits the machine's energy between the sessions running at
+// the same time. Every interval it takes the monitor's power reading and
+// charges each running session by the CPU time of its target processes,
+// so overlapping sessions are not each charged the whole machine.
+type attributor struct {
+	policy   string
+	interval time.Duration
+
+	mu           sync.Mutex
+	since        time.Time
+	joules       float64
+	attributed   float64
+	unattributed float64
+}
+
+// attribution is set by Run when attribution is enabled.
+var attribution *attributor
+
+func newAttributor(policy string, interval time.Duration) *attributor {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &attributor{policy: policy, interval: interval, since: time.Now()}
+}
+
+// runningSessions returns the sessions still sampling, oldest first.
+func runningSessions() []*Session {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	var running []*Session
+	for _, s := range sessions.sessions {
+		if s.State() == stateRunning {
+			running = append(running, s)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].StartTime.Before(running[j].StartTime) })
+	return running
+}
+
+func (a *attributor) run() {
+	machine := newMachineSampler()
+
+	// targets holds a sampler per session with a target. A session is
+	// picked up on the first tick after it starts, so its processes are
+	// charged from then on.
+	targets := make(map[*Session]*analysis.TargetSampler)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		seconds := now.Sub(last).Seconds()
+		last = now
+
+		model := activeModel()
+		sample := machine.sample(now, model)
+
+		running := runningSessions()
+		live := make(map[*Session]bool, len(running))
+		for _, s := range running {
+			live[s] = true
+		}
+		for s := range targets {
+			if !live[s] {
+				delete(targets, s)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+
+		watts := predictPower(model, sample)
+		if machineMonitor.measured() {
+			if r := machineMonitor.reading(); r.err != nil {
+				log.Println("attribution: power read failed, using the model:", r.err)
+			} else {
+				watts = r.watts
+			}
+		}
+
+		shares := make([]float64, len(running))
+		for i, s := range running {
+			if s.target == nil {
+				continue
+			}
+			ts, ok := targets[s]
+			if !ok {
+				targets[s] = analysis.NewTargetSampler(s.target)
+				continue
+			}
+			shares[i] = ts.Sample().Cpu
+		}
+
+		joules := watts * seconds
+		idle := currentProfile().IdleWatts * seconds
+		direct, remainder, unattributed := analysis.Attribute(joules, idle, sample.Cpu, shares, a.policy)
+		attributed := 0.0
+		for i, s := range running {
+			if s.attribute(a.policy, direct[i], remainder[i], shares[i], seconds) {
+				attributed += direct[i] + remainder[i]
+			} else {
+				// The session stopped during the tick.
+				unattributed += direct[i] + remainder[i]
+			}
+		}
+
+		a.mu.Lock()
+		a.joules += joules
+		a.attributed += attributed
+		a.unattributed += unattributed
+		a.mu.Unlock()
+	}
+}
+
+// attribute adds the energy charged to the session for one interval. It
+// reports false when the session stopped in the meantime.
+func (s *Session) attribute(policy string, direct, remainder, share, seconds float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != stateRunning {
+		return false
+	}
+	if s.attribution == nil {
+		s.attribution = &analysis.Attribution{Policy: policy}
+	}
+	s.attribution.Add(direct, remainder, share, seconds)
+	return true
+}
+
+// SessionAttribution is the energy charged so far to one running session.
+type SessionAttribution struct {
+	ID          string                `json:"id"`
+	Tags        map[string]string     `json:"tags,omitempty"`
+	Attribution *analysis.Attribution `json:"attribution"`
+}
+
+// AttributionReport is the answer of GET /attribution. Joules is the
+// machine's energy over every interval some session ran in; Attributed and
+// Unattributed add up to it.
+type AttributionReport struct {
+	Policy       string               `json:"policy"`
+	Since        time.Time            `json:"since"`
+	Joules       float64              `json:"joules"`
+	Attributed   float64              `json:"attributed"`
+	Unattributed float64              `json:"unattributed"`
+	Sessions     []SessionAttribution `json:"sessions"`
+}
+
+func (a *attributor) report() AttributionReport {
+	a.mu.Lock()
+	r := AttributionReport{
+		Policy:       a.policy,
+		Since:        a.since,
+		Joules:       a.joules,
+		Attributed:   a.attributed,
+		Unattributed: a.unattributed,
+		Sessions:     make([]SessionAttribution, 0),
+	}
+	a.mu.Unlock()
+
+	for _, s := range runningSessions() {
+		s.mu.Lock()
+		if s.attribution != nil {
+			charged := *s.attribution
+			r.Sessions = append(r.Sessions, SessionAttribution{ID: s.ID, Tags: s.Tags, Attribution: &charged})
+		}
+		s.mu.Unlock()
+	}
+	return r
+}
+
+// GetAttribution reports how the machine's energy was split between the
+// sessions since the server started.
+func GetAttribution(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if attribution == nil {
+		writeError(w, http.StatusNotFound, "energy attribution is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, attribution.report())
+}
@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"analysis-model/pkg/power"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultBaselineWindow is how long a baseline capture samples by default.
+const defaultBaselineWindow = 30 * time.Second
+
+// BaselineRequest is the body of POST /baseline.
+type BaselineRequest struct {
+	Window   Duration `json:"window,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// captureBaseline samples the quiet system for window and stores the
+// averages as the idle baseline of the current profile.
+func captureBaseline(window, interval time.Duration) (power.Profile, error) {
+	if window <= 0 {
+		window = defaultBaselineWindow
+	}
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	options := Options{Interval: Duration(interval), MaxDuration: Duration(window)}
+	if err := options.Validate(); err != nil {
+		return power.Profile{}, err
+	}
+
+	p := currentProfile()
+	log.Println("baseline: capturing", p.Name, "for", window)
+	s := startSession(false, MeasurementRequest{
+		Options: options,
+		Tags:    map[string]string{"baseline": p.Name},
+	})
+	result := s.Wait()
+	if result.Samples == 0 || result.Integrated == nil {
+		return p, errors.New("baseline: no samples taken")
+	}
+
+	p.Baseline = &power.Baseline{
+		Cpu:        result.Cpu,
+		Memory:     result.Memory,
+		Watts:      result.Integrated.AvgWatts,
+		Source:     result.Integrated.Source,
+		Samples:    result.Samples,
+		Window:     window.Seconds(),
+		CapturedAt: time.Now(),
+	}
+	p.IdleWatts = p.Baseline.Watts
+	if err := profiles.Put(p); err != nil {
+		return p, err
+	}
+	log.Printf("baseline: %s idles at %.2f W (%s), cpu %.2f%% mem %.2f%%", p.Name, p.IdleWatts, p.Baseline.Source, p.Baseline.Cpu, p.Baseline.Memory)
+	return p, nil
+}
+
+// CaptureBaseline samples the quiet system and stores its idle CPU,
+// memory and power in the current device profile. It answers once the
+// window has passed, with the updated profile.
+func CaptureBaseline(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req BaselineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := captureBaseline(time.Duration(req.Window), time.Duration(req.Interval))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// driftMonitor compares the active model's prediction with the measured
+// power of the machine samples the monitor takes.
+type driftMonitor struct {
+	mu         sync.Mutex
+	thresholds power.DriftThresholds
+	tracker    *power.DriftTracker
+	model      string
+}
+
+// drift is set by Run.
+var drift = newDriftMonitor(power.DriftThresholds{})
+
+func newDriftMonitor(thresholds power.DriftThresholds) *driftMonitor {
+	return &driftMonitor{
+		thresholds: thresholds,
+		tracker:    power.NewDriftTracker(thresholds),
+	}
+}
+
+// observe adds a measured sample. Activating another model starts the
+// statistics over.
+func (d *driftMonitor) observe(sample analysis.Sample) {
+	model := activeModel()
+	predicted := predictPower(model, sample)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if model.ID != d.model {
+		d.tracker = power.NewDriftTracker(d.thresholds)
+		d.model = model.ID
+	}
+	status, changed := d.tracker.Observe(predicted, sample.Power)
+	if changed {
+		r := d.tracker.Report()
+		log.Printf("model drift: %s is %s, rolling MAPE %.2f%% bias %.2f W over %d samples", d.model, status, r.MAPE, r.Bias, r.Samples)
+	}
+}
+
+func (d *driftMonitor) report() power.DriftReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := d.tracker.Report()
+	r.Model = d.model
+	if r.Model == "" {
+		r.Model = activeModel().ID
+	}
+	return r
+}
+
+// GetDrift reports how far the active model's predictions are from the
+// measured power.
+func GetDrift(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, drift.report())
+}
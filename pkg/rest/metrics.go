@@ -0,0 +1,198 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mackerelio/go-osstat/memory"
+)
+
+// exporter keeps the state needed between two Prometheus scrapes: the last
+// /proc/stat counters for utilisation and the running energy counters.
+type exporter struct {
+	mu sync.Mutex
+
+	lastIdle   uint64
+	lastTotal  uint64
+	lastScrape time.Time
+
+	predictedJoules float64
+	measuredJoules  float64
+
+	// features samples the extra features of the active model; it is
+	// replaced when the active model needs different ones.
+	features     *analysis.FeatureSampler
+	featureNames string
+}
+
+var metricsExporter = &exporter{}
+
+// nodeType labels the metrics with the device profile the server runs
+// under, such as "host" or "csd". A profile made up for undetected
+// hardware has no name and is labelled by its architecture, or "unknown"
+// when that is missing too.
+func nodeType() string {
+	switch {
+	case profile.Name != "":
+		return profile.Name
+	case profile.Arch != "":
+		return profile.Arch
+	}
+	return "unknown"
+}
+
+type metricWriter struct {
+	buf  bytes.Buffer
+	node string
+}
+
+func (m *metricWriter) header(name, kind, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metricWriter) value(name, labels string, v float64) {
+	if labels != "" {
+		labels = "," + labels
+	}
+	fmt.Fprintf(&m.buf, "%s{node=%q%s} %g\n", name, m.node, labels, v)
+}
+
+func (m *metricWriter) metric(name, kind, help string, v float64) {
+	m.header(name, kind, help)
+	m.value(name, "", v)
+}
+
+// scrape reads the current host state and renders it in the Prometheus
+// text exposition format.
+func (e *exporter) scrape() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	idle, total := analysis.CPUTicks()
+	cpuUsage := 0.0
+	if !e.lastScrape.IsZero() && total > e.lastTotal {
+		idleTicks := float64(idle - e.lastIdle)
+		totalTicks := float64(total - e.lastTotal)
+		cpuUsage = 100 * (totalTicks - idleTicks) / totalTicks
+	}
+
+	m := &metricWriter{node: nodeType()}
+
+	m.header("analysis_model_cpu_ticks_total", "counter", "Cumulative CPU ticks from /proc/stat by mode.")
+	m.value("analysis_model_cpu_ticks_total", `mode="busy"`, float64(total-idle))
+	m.value("analysis_model_cpu_ticks_total", `mode="idle"`, float64(idle))
+	if all, _, err := analysis.ReadCPUStats(); err == nil {
+		m.header("analysis_model_cpu_state_ticks_total", "counter", "Cumulative CPU ticks from /proc/stat by state; guest is also counted in user and nice.")
+		for _, state := range []struct {
+			name  string
+			ticks uint64
+		}{
+			{"user", all.User}, {"nice", all.Nice}, {"system", all.System}, {"idle", all.Idle},
+			{"iowait", all.IOWait}, {"irq", all.IRQ}, {"softirq", all.SoftIRQ}, {"steal", all.Steal},
+			{"guest", all.Guest + all.GuestNice},
+		} {
+			m.value("analysis_model_cpu_state_ticks_total", fmt.Sprintf("state=%q", state.name), float64(state.ticks))
+		}
+	}
+	m.metric("analysis_model_cpu_utilization_percent", "gauge", "CPU utilisation since the previous scrape.", cpuUsage)
+
+	memUsage := 0.0
+	mem, err := memory.Get()
+	if err != nil {
+		log.Println(err)
+	} else {
+		memUsage = 100 * (float64(mem.Used) / float64(mem.Total))
+		m.metric("analysis_model_memory_total_bytes", "gauge", "Total memory.", float64(mem.Total))
+		m.metric("analysis_model_memory_used_bytes", "gauge", "Used memory.", float64(mem.Used))
+		m.metric("analysis_model_memory_utilization_percent", "gauge", "Used memory as a share of total memory.", memUsage)
+	}
+
+	model := activeModel()
+	if names := strings.Join(model.Features(), ","); e.features == nil || names != e.featureNames {
+		e.features = analysis.NewFeatureSampler(model.Features())
+		e.featureNames = names
+	}
+	predicted := predictPower(model, analysis.Sample{Cpu: cpuUsage, Memory: memUsage, Features: e.features.Sample()})
+	m.metric("analysis_model_predicted_power_watts", "gauge", "Power predicted by the regression model.", predicted)
+
+	var measured float64
+	hasMeasured := false
+	var columns map[string]float64
+	if machineMonitor.measured() {
+		r := machineMonitor.reading()
+		if r.err != nil {
+			log.Println(r.err)
+		} else {
+			measured, columns, hasMeasured = r.watts, r.detail, true
+		}
+	}
+	if hasMeasured {
+		m.header("analysis_model_package_power_watts", "gauge", "Package power reported by the power source.")
+		m.value("analysis_model_package_power_watts", fmt.Sprintf("source=%q", machineMonitor.source.Name()), measured)
+	}
+	if len(columns) > 0 {
+		m.header("analysis_model_power_detail", "gauge", "Per-column readings of the power source, such as turbostat CorWatt or PkgTmp.")
+		names := make([]string, 0, len(columns))
+		for column := range columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+		for _, column := range names {
+			m.value("analysis_model_power_detail", fmt.Sprintf("column=%q", column), columns[column])
+		}
+	}
+
+	if !e.lastScrape.IsZero() {
+		elapsed := now.Sub(e.lastScrape).Seconds()
+		e.predictedJoules += predicted * elapsed
+		if hasMeasured {
+			e.measuredJoules += measured * elapsed
+		}
+	}
+	m.metric("analysis_model_predicted_energy_joules_total", "counter", "Predicted energy accumulated between scrapes.", e.predictedJoules)
+	if hasMeasured {
+		m.metric("analysis_model_measured_energy_joules_total", "counter", "Measured package energy accumulated between scrapes.", e.measuredJoules)
+	}
+
+	// Stopped sessions move to the history once they are written; the
+	// ones still in memory are either being written or failed to be.
+	running, stopped := 0, 0
+	if history != nil {
+		stopped = history.Len()
+	}
+	sessions.mu.Lock()
+	for id, s := range sessions.sessions {
+		if s.State() == stateRunning {
+			running++
+		} else if history == nil {
+			stopped++
+		} else if _, err := history.Get(id); err != nil {
+			stopped++
+		}
+	}
+	sessions.mu.Unlock()
+	m.header("analysis_model_measurement_sessions", "gauge", "Measurement sessions by state.")
+	m.value("analysis_model_measurement_sessions", `state="running"`, float64(running))
+	m.value("analysis_model_measurement_sessions", `state="stopped"`, float64(stopped))
+
+	e.lastIdle, e.lastTotal, e.lastScrape = idle, total, now
+
+	return m.buf.Bytes()
+}
+
+// ServeMetrics serves host and CSD resource metrics for Prometheus.
+func ServeMetrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	body := metricsExporter.scrape()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(body)
+}
@@ -0,0 +1,128 @@
+package rest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"analysis-model/pkg/power"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// models is the power model store opened by Run.
+var models *power.ModelStore
+
+// TrainRequest is the body of POST /models.
+type TrainRequest struct {
+	Dataset string `json:"dataset"`
+
+	// Features selects the dataset columns to train on; all of them when
+	// empty.
+	Features []string `json:"features,omitempty"`
+
+	// TrainOptions selects the fitting method and configures the
+	// cross-validation and holdout run.
+	power.TrainOptions
+}
+
+type datasetInfo struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+}
+
+// UploadDataset stores a training CSV sent as the request body. Power is
+// the first column; an optional header row names the feature in each of the
+// others, which default to cpu and mem.
+func UploadDataset(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	records, err := csv.NewReader(r.Body).ReadAll()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := ps.ByName("name")
+	records, err = models.SaveDataset(name, records)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, datasetInfo{Name: name, Records: len(records)})
+}
+
+// ListDatasets lists the stored training datasets.
+func ListDatasets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, models.Datasets())
+}
+
+// TrainModel fits a new model version on a stored dataset.
+func TrainModel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req TrainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m, err := models.Train(req.Dataset, req.Features, req.TrainOptions)
+	if err == power.ErrDatasetNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, m)
+}
+
+// ListModels lists every model version with its fit metrics.
+func ListModels(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, models.List())
+}
+
+// GetModel returns the coefficients of one model version.
+func GetModel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	m, err := models.Get(ps.ByName("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// GetResiduals returns the out-of-fold residuals of a model version as CSV.
+func GetResiduals(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	m, err := models.Get(ps.ByName("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if m.Evaluation == nil {
+		writeError(w, http.StatusNotFound, "model has no evaluation")
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	if err := m.Evaluation.WriteResidualsCSV(w); err != nil {
+		log.Println(err)
+	}
+}
+
+// ActivateModel makes a model version the one used for prediction by new
+// sessions. A current profile that names a model is moved to it.
+func ActivateModel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	err := models.Activate(id)
+	if err == power.ErrModelNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err == nil {
+		err = followModel(id)
+	}
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	m, _ := models.Get(id)
+	writeJSON(w, http.StatusOK, m)
+}
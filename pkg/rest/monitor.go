@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+)
+
+// machineSampler takes whole-machine samples with the features of the
+// model they are predicted with.
+type machineSampler struct {
+	cpu          *analysis.CPUSampler
+	features     *analysis.FeatureSampler
+	featureNames string
+}
+
+func newMachineSampler() *machineSampler {
+	return &machineSampler{cpu: analysis.NewCPUSampler()}
+}
+
+// sample returns the usage since the previous call. The feature sampler is
+// replaced when the model's features change.
+func (m *machineSampler) sample(now time.Time, model *power.Model) analysis.Sample {
+	sample := analysis.Sample{Time: now, Cpu: m.cpu.Sample()}
+	mem, err := analysis.MemUsage()
+	if err != nil {
+		log.Println(err)
+	}
+	sample.Memory = mem
+	if names := strings.Join(model.Features(), ","); m.features == nil || names != m.featureNames {
+		m.features = analysis.NewFeatureSampler(model.Features())
+		m.featureNames = names
+	}
+	sample.Features = m.features.Sample()
+	return sample
+}
+
+// powerReading is one reading of the shared power source.
+type powerReading struct {
+	watts  float64
+	detail map[string]float64
+	err    error
+}
+
+// errNoReading is reported until the monitor has read the source once.
+var errNoReading = errors.New("no power reading yet")
+
+// monitor owns the server's one power source. It reads it once per
+// interval and hands the reading to sessions, the attributor and the
+// metrics, so a turbostat source runs one process whatever the number of
+// sessions. While sessions run it also feeds the machine sample to the
+// drift monitor and the online updater; sessions overlap, so the model is
+// checked and refined against the machine and not against every session's
+// copy of it.
+type monitor struct {
+	interval time.Duration
+	source   power.PowerSource
+
+	mu     sync.Mutex
+	latest powerReading
+}
+
+// machineMonitor is set by Run.
+var machineMonitor = newMonitor(power.NewModelSource(), defaultInterval)
+
+func newMonitor(source power.PowerSource, interval time.Duration) *monitor {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &monitor{interval: interval, source: source, latest: powerReading{err: errNoReading}}
+}
+
+// measured reports whether the source measures power at all.
+func (mon *monitor) measured() bool {
+	return mon.source.Measured()
+}
+
+// reading returns the average power over the monitor's last interval.
+func (mon *monitor) reading() powerReading {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	return mon.latest
+}
+
+func (mon *monitor) run() {
+	defer mon.source.Close()
+	if !mon.measured() {
+		log.Println("monitor: no measured power source")
+		return
+	}
+	machine := newMachineSampler()
+	powerFailed := false
+
+	ticker := time.NewTicker(mon.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sample := machine.sample(now, activeModel())
+		// Read on every tick, so a reading never averages over the
+		// time since the last session.
+		r := powerReading{}
+		r.watts, r.err = mon.source.Read()
+		if detail, ok := mon.source.(power.DetailSource); ok && r.err == nil {
+			r.detail = detail.Detail()
+		}
+		mon.mu.Lock()
+		mon.latest = r
+		mon.mu.Unlock()
+
+		if r.err != nil {
+			if !powerFailed {
+				log.Println("monitor: power read failed:", r.err)
+				powerFailed = true
+			}
+			continue
+		}
+		if len(runningSessions()) == 0 {
+			continue
+		}
+		sample.Power = r.watts
+		sample.Measured = true
+		drift.observe(sample)
+		if online != nil {
+			online.observe(sample)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+)
+
+// onlineUpdater refines the active model from the machine samples the
+// monitor measures while sessions run and checkpoints it into the model
+// store.
+type onlineUpdater struct {
+	mu         sync.Mutex
+	forgetting float64
+	rls        *power.RLS
+
+	// current is the ID of the model rls refines: the active model it
+	// started from, then its checkpoint.
+	current string
+}
+
+// defaultCheckpoint is the checkpoint interval used when none is given.
+const defaultCheckpoint = 5 * time.Minute
+
+// online is set by Run when online updating is enabled.
+var online *onlineUpdater
+
+func newOnlineUpdater(forgetting float64) *onlineUpdater {
+	if forgetting == 0 {
+		forgetting = power.DefaultForgetting
+	}
+	return &onlineUpdater{forgetting: forgetting}
+}
+
+// observe folds one measured sample into the active model. Activating
+// another model restarts the updater from it.
+func (u *onlineUpdater) observe(sample analysis.Sample) {
+	active := activeModel()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if active.ID != u.current {
+		var err error
+		u.current = active.ID
+		u.rls, err = power.NewRLS(active, u.forgetting, power.DefaultCovariance)
+		if err != nil {
+			log.Println("online update disabled for", active.ID+":", err)
+		}
+	}
+	if u.rls == nil {
+		return
+	}
+	u.rls.Update(sampleFeatures(sample), sample.Power)
+}
+
+// checkpoint saves the refined model when it has new updates.
+func (u *onlineUpdater) checkpoint() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.rls == nil || u.rls.Updates() == 0 || models == nil {
+		return
+	}
+	saved, err := models.Checkpoint(u.rls.Model())
+	if err != nil {
+		log.Println("online checkpoint failed:", err)
+		return
+	}
+	if err := followModel(saved.ID); err != nil {
+		log.Println("online checkpoint:", err)
+	}
+	// Continue from the saved version so that the next checkpoint
+	// overwrites it instead of forking another one.
+	u.rls.Rebase(saved)
+	u.current = saved.ID
+}
+
+// run checkpoints every interval until the process exits.
+func (u *onlineUpdater) run(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultCheckpoint
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.checkpoint()
+	}
+}
@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"analysis-model/pkg/power"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// profiles is the device profile registry opened by Run, and profile the
+// profile this server runs under.
+var (
+	profiles *power.ProfileRegistry
+	profile  power.Profile
+)
+
+type profileList struct {
+	Current  string          `json:"current"`
+	Profiles []power.Profile `json:"profiles"`
+}
+
+// currentProfile returns the server's profile as last saved in the
+// registry, so edits apply without a restart.
+func currentProfile() power.Profile {
+	if profiles != nil {
+		if p, err := profiles.Get(profile.Name); err == nil {
+			return p
+		}
+	}
+	return profile
+}
+
+// followModel points the current profile at a newly activated model when
+// the profile names one. The profile's model takes precedence over the
+// store's active one, so activations and online checkpoints would not take
+// effect otherwise.
+func followModel(id string) error {
+	p := currentProfile()
+	if profiles == nil || p.Model == "" || p.Model == id {
+		return nil
+	}
+	p.Model = id
+	if err := profiles.Put(p); err != nil {
+		return err
+	}
+	log.Println("profile", p.Name, "now uses model", id)
+	return nil
+}
+
+// ListProfiles lists the device profiles and names the current one.
+func ListProfiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, profileList{Current: profile.Name, Profiles: profiles.List()})
+}
+
+// GetProfile returns one device profile.
+func GetProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, err := profiles.Get(ps.ByName("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// PutProfile creates or replaces a device profile. Its model has to be a
+// stored model version.
+func PutProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var p power.Profile
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p.Name = ps.ByName("name")
+	if p.Model != "" {
+		if _, err := models.Get(p.Model); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := profiles.Put(p); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
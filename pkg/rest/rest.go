@@ -2,17 +2,71 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"analysis-model/pkg/analysis"
 	"analysis-model/pkg/power"
+	"analysis-model/pkg/store"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var flag = 1
-var onCSD = 0
+// history is the on-disk measurement store opened by Run.
+var history *store.Store
+
+// Config holds the server settings chosen on the command line.
+type Config struct {
+	Addr    string
+	DataDir string
+
+	// PowerSource is one of the power.Source names and PowercapRoot the
+	// sysfs tree the RAPL backend reads.
+	PowerSource  string
+	PowercapRoot string
+
+	// Online enables refining the active model from measured samples with
+	// the given RLS forgetting factor, checkpointed every Checkpoint.
+	Online     bool
+	Forgetting float64
+	Checkpoint time.Duration
+
+	// Drift configures the comparison of predicted and measured power.
+	Drift power.DriftThresholds
+
+	// Profile names the device profile to run under; detected from the
+	// hardware when empty.
+	Profile string
+
+	// Baseline, when set, captures the idle baseline of the profile for
+	// that long before serving.
+	Baseline time.Duration
+
+	// Attribution, when set, splits the machine's energy between the
+	// running sessions every AttributionInterval, charging idle and
+	// untracked energy by this analysis remainder policy.
+	Attribution         string
+	AttributionInterval time.Duration
+}
+
+// Validate rejects settings the server cannot run with.
+func (c Config) Validate() error {
+	if err := c.Drift.Validate(); err != nil {
+		return err
+	}
+	if c.Attribution != "" {
+		return analysis.ValidRemainder(c.Attribution)
+	}
+	return nil
+}
 
 type Metrics struct {
 	CPU    []string
@@ -20,95 +74,333 @@ type Metrics struct {
 	Power  []string
 }
 
-func StartMeasure(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	log.Println("Measure Start Request")
-	cpuChan := make(chan float64)
-	memChan := make(chan float64)
-	powerChan := make(chan float64)
-	var cpuList []float64
-	var memList []float64
-	var powerList []float64
-	var powerAvg float64
-
-	if onCSD != 0 {
-		fp := power.NewFormula()
-		for {
-			if flag == 0 {
-				break
+// Duration is a time.Duration that reads and writes JSON as a string such
+// as "250ms" or "5m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Options control how a session samples. A zero MaxDuration or MaxSamples
+// means the session runs until it is stopped. Disks selects the block
+// devices by shell pattern, such as "nvme0n1" or "nvme*"; every whole
+// disk is sampled when it is empty.
+type Options struct {
+	Interval    Duration `json:"interval,omitempty"`
+	MaxDuration Duration `json:"maxDuration,omitempty"`
+	MaxSamples  int      `json:"maxSamples,omitempty"`
+	Disks       []string `json:"disks,omitempty"`
+}
+
+// Validate rejects sampling options the sampler cannot honour.
+func (o Options) Validate() error {
+	if o.Interval != 0 && time.Duration(o.Interval) < minInterval {
+		return fmt.Errorf("interval must be at least %s", minInterval)
+	}
+	if o.MaxDuration < 0 {
+		return errors.New("maxDuration must not be negative")
+	}
+	if o.MaxSamples < 0 {
+		return errors.New("maxSamples must not be negative")
+	}
+	return analysis.ValidDiskPatterns(o.Disks)
+}
+
+// optionsFromQuery reads sampling options from URL parameters, for the
+// legacy GET endpoints that take no body.
+func optionsFromQuery(query url.Values) (Options, error) {
+	var o Options
+	for name, d := range map[string]*Duration{"interval": &o.Interval, "maxDuration": &o.MaxDuration} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return o, err
 			}
-			go analysis.GetCPU(cpuChan)
-			go analysis.GetMem(memChan)
-			go fp.GetPower(powerChan)
-			cpuList = append(cpuList, <-cpuChan)
-			memList = append(memList, <-memChan)
-			powerList = append(powerList, <-powerChan)
+			*d = Duration(parsed)
 		}
-	} else {
-		for {
-			if flag == 0 {
-				break
-			}
-			go analysis.GetCPU(cpuChan)
-			go analysis.GetMem(memChan)
-			cpuList = append(cpuList, <-cpuChan)
-			memList = append(memList, <-memChan)
-
+	}
+	if v := query.Get("maxSamples"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return o, err
 		}
+		o.MaxSamples = n
 	}
+	o.Disks = query["disk"]
+	return o, o.Validate()
+}
+
+// MeasurementRequest is the optional body of POST /measurements. Tags such
+// as the query text, device or dataset scale are stored with the result.
+type MeasurementRequest struct {
+	Options
+	Tags   map[string]string `json:"tags,omitempty"`
+	Target *analysis.Target  `json:"target,omitempty"`
+}
 
-	cpuTotal := 0.0
-	for _, cpu := range cpuList {
-		cpuTotal = cpuTotal + cpu
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonString, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonString)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// CreateMeasurement starts a new session and returns its ID right away.
+func CreateMeasurement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req MeasurementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	cpuAvg := cpuTotal / float64(len(cpuList))
-	memTotal := 0.0
-	for _, mem := range memList {
-		memTotal = memTotal + mem
+	if req.Target != nil {
+		if err := req.Target.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
-	memAvg := memTotal / float64(len(memList))
 
-	if onCSD == 0 {
-		powerTotal := 0.0
-		for _, power := range powerList {
-			powerTotal = powerTotal + power
+	s := startSession(false, req)
+	writeJSON(w, http.StatusCreated, s.Info())
+}
+
+// ListMeasurements lists finished measurements from the history. Results
+// can be narrowed with repeated tag=key:value parameters and an RFC 3339
+// from/to range on the start time.
+func ListMeasurements(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	filter := store.Filter{Tags: make(map[string]string)}
+	for _, tag := range query["tag"] {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) == 2 {
+			filter.Tags[kv[0]] = kv[1]
+		} else {
+			filter.Tags[kv[0]] = ""
+		}
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		powerAvg = powerTotal / float64(len(powerList))
 	}
 
-	log.Println("CPU Usage", cpuAvg)
-	log.Println("MEM Usage", memAvg)
-	if onCSD == 0 {
-		log.Println("POWER Usage", powerAvg)
+	list := make([]SessionInfo, 0)
+	for _, rec := range history.List(filter) {
+		list = append(list, recordInfo(rec))
 	}
+	writeJSON(w, http.StatusOK, list)
+}
 
-	predict := 96.2107 + (cpuAvg * -(0.4059)) + (memAvg * (-17.2624))
-	log.Println("POWER Usage", predict)
+// GetMeasurement reports the state of a session and its summary so far.
+// Sessions from before a restart are served from the history.
+func GetMeasurement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if s, ok := getSession(id); ok {
+		writeJSON(w, http.StatusOK, s.Info())
+		return
+	}
+	rec, err := history.Get(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "measurement not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, recordInfo(rec))
+}
 
-	measure := analysis.Analysis{
-		Cpu:    cpuAvg,
-		Memory: memAvg,
-		Energy: predict,
+// GetMeasurementSamples returns the raw samples of a session.
+func GetMeasurementSamples(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if s, ok := getSession(id); ok {
+		writeJSON(w, http.StatusOK, s.Samples())
+		return
+	}
+	samples, err := history.Samples(id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "measurement not found")
+		return
 	}
-	log.Println(measure)
-	jsonString, err := json.Marshal(measure)
 	if err != nil {
 		log.Println(err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
+	writeJSON(w, http.StatusOK, samples)
+}
 
-	w.Write(jsonString)
+// StopMeasurement ends a session and returns its final result. Stopping a
+// session that already ended returns its result from the history.
+func StopMeasurement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	s, ok := getSession(id)
+	if !ok {
+		rec, err := history.Get(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "measurement not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, recordInfo(rec))
+		return
+	}
+	s.Stop()
+	writeJSON(w, http.StatusOK, s.Info())
+}
+
+// StartMeasure is the legacy blocking endpoint. It runs a session until
+// EndMeasure is called, or until the optional interval, maxDuration and
+// maxSamples query parameters end it, and then answers with the averaged
+// result. Repeated disk parameters select the block devices.
+func StartMeasure(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	log.Println("Measure Start Request")
+	options, err := optionsFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := startSession(true, MeasurementRequest{Options: options})
+
+	measure := s.Wait()
+	writeJSON(w, http.StatusOK, measure)
 }
 
+// EndMeasure stops every session started through StartMeasure.
 func EndMeasure(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	log.Println("Measure End Request")
 
-	flag = 0
+	sessions.mu.Lock()
+	var legacy []*Session
+	for _, s := range sessions.sessions {
+		if s.legacy {
+			legacy = append(legacy, s)
+		}
+	}
+	sessions.mu.Unlock()
 
+	for _, s := range legacy {
+		s.Stop()
+	}
 }
 
-func Run() {
+func Run(cfg Config) {
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	history, err = store.Open(cfg.DataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	models, err = power.OpenModelStore(filepath.Join(cfg.DataDir, "power"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	profiles, err = power.OpenProfiles(filepath.Join(cfg.DataDir, "power", "profiles.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	hw, err := power.DetectHardware()
+	if err != nil {
+		log.Println("hardware detection failed:", err)
+	}
+	profile, err = profiles.Select(cfg.Profile, hw)
+	if err != nil {
+		log.Fatal(cfg.Profile, ": ", err)
+	}
+	log.Println("device profile:", profile.Name, profile.Arch, hw.ModelName)
+	source, err := power.Open(cfg.PowerSource, cfg.PowercapRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("power source:", source.Name())
+	machineMonitor = newMonitor(source, defaultInterval)
+	drift = newDriftMonitor(cfg.Drift)
+	if cfg.Online {
+		if !source.Measured() {
+			log.Fatal("online model updates need a measured power source")
+		}
+		online = newOnlineUpdater(cfg.Forgetting)
+		go online.run(cfg.Checkpoint)
+	}
+	go machineMonitor.run()
+
+	if cfg.Attribution != "" {
+		attribution = newAttributor(cfg.Attribution, cfg.AttributionInterval)
+		go attribution.run()
+	}
+
+	if cfg.Baseline > 0 {
+		if _, err := captureBaseline(cfg.Baseline, defaultInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	router := httprouter.New()
 	router.GET("/start/measure", StartMeasure)
 	router.GET("/end/measure", EndMeasure)
 
-	log.Fatal(http.ListenAndServe(":50500", router))
+	router.POST("/measurements", CreateMeasurement)
+	router.GET("/measurements", ListMeasurements)
+	router.GET("/measurements/:id", GetMeasurement)
+	router.GET("/measurements/:id/samples", GetMeasurementSamples)
+	router.DELETE("/measurements/:id", StopMeasurement)
+	router.POST("/measurements/:id/stop", StopMeasurement)
+	router.GET("/measurements/:id/stream", StreamMeasurement)
+
+	router.GET("/metrics", ServeMetrics)
+	router.GET("/drift", GetDrift)
+	router.GET("/attribution", GetAttribution)
+
+	router.GET("/profiles", ListProfiles)
+	router.GET("/profiles/:name", GetProfile)
+	router.PUT("/profiles/:name", PutProfile)
+	router.POST("/baseline", CaptureBaseline)
+
+	router.GET("/datasets", ListDatasets)
+	router.PUT("/datasets/:name", UploadDataset)
+	router.POST("/datasets/:name", UploadDataset)
+	router.GET("/models", ListModels)
+	router.POST("/models", TrainModel)
+	router.GET("/models/:id", GetModel)
+	router.GET("/models/:id/residuals", GetResiduals)
+	router.POST("/models/:id/activate", ActivateModel)
+
+	log.Fatal(http.ListenAndServe(cfg.Addr, router))
 }
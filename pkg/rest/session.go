@@ -0,0 +1,388 @@
+package rest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+	"analysis-model/pkg/store"
+)
+
+const (
+	stateRunning = "running"
+	stateStopped = "stopped"
+)
+
+// Reasons a session stopped sampling.
+const (
+	stopRequested   = "requested"
+	stopMaxDuration = "maxDuration"
+	stopMaxSamples  = "maxSamples"
+)
+
+const (
+	defaultInterval = time.Second
+	minInterval     = 50 * time.Millisecond
+)
+
+// Session is one measurement run. Every session owns its sampler goroutine,
+// so several clients can measure overlapping queries at the same time.
+type Session struct {
+	ID        string
+	StartTime time.Time
+	EndTime   time.Time
+
+	Tags map[string]string
+
+	legacy  bool
+	target  *analysis.Target
+	options Options
+	model   *power.Model
+	profile string
+
+	// idleWatts is the baseline of the profile, 0 when it has none.
+	idleWatts float64
+
+	mu          sync.Mutex
+	state       string
+	stopReason  string
+	samples     []analysis.Sample
+	hasPower    bool
+	result      *analysis.Analysis
+	attribution *analysis.Attribution
+	subscribers map[chan analysis.Sample]struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// SessionInfo is the JSON view of a session returned by the REST API.
+type SessionInfo struct {
+	ID         string             `json:"id"`
+	State      string             `json:"state"`
+	Tags       map[string]string  `json:"tags,omitempty"`
+	StartTime  time.Time          `json:"startTime"`
+	EndTime    *time.Time         `json:"endTime,omitempty"`
+	Options    Options            `json:"options"`
+	Samples    int                `json:"samples"`
+	StopReason string             `json:"stopReason,omitempty"`
+	Result     *analysis.Analysis `json:"result,omitempty"`
+}
+
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+var sessions = &sessionRegistry{sessions: make(map[string]*Session)}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println(err)
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// startSession registers a new session and starts sampling in the background.
+// Legacy sessions are the ones stopped by the global EndMeasure call. A
+// request must already be validated.
+func startSession(legacy bool, req MeasurementRequest) *Session {
+	if req.Interval == 0 {
+		req.Interval = Duration(defaultInterval)
+	}
+	profile := currentProfile()
+	s := &Session{
+		ID:          newSessionID(),
+		StartTime:   time.Now(),
+		Tags:        req.Tags,
+		legacy:      legacy,
+		target:      req.Target,
+		options:     req.Options,
+		model:       activeModel(),
+		profile:     profile.Name,
+		idleWatts:   profile.IdleWatts,
+		state:       stateRunning,
+		subscribers: make(map[chan analysis.Sample]struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	sessions.mu.Lock()
+	sessions.sessions[s.ID] = s
+	sessions.mu.Unlock()
+
+	log.Println("Measure Start", s.ID)
+	go s.run()
+
+	return s
+}
+
+// getSession looks up a session by ID.
+func getSession(id string) (*Session, bool) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	s, ok := sessions.sessions[id]
+	return s, ok
+}
+
+func (s *Session) run() {
+	defer close(s.done)
+
+	cpu := analysis.NewCPUSampler()
+	var target *analysis.TargetSampler
+	if s.target != nil {
+		target = analysis.NewTargetSampler(s.target)
+	}
+	features := analysis.NewFeatureSampler(s.model.Features())
+	disks := analysis.NewDiskSampler(s.options.Disks)
+	s.mu.Lock()
+	s.hasPower = machineMonitor.measured()
+	s.mu.Unlock()
+	powerFailed := false
+
+	ticker := time.NewTicker(time.Duration(s.options.Interval))
+	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if s.options.MaxDuration > 0 {
+		timer := time.NewTimer(time.Duration(s.options.MaxDuration))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	reason := stopRequested
+	for {
+		select {
+		case <-s.stop:
+		case <-deadline:
+			reason = stopMaxDuration
+		case now := <-ticker.C:
+			sample := analysis.Sample{
+				Time: now,
+				Cpu:  cpu.Sample(),
+			}
+			detail := cpu.Detail()
+			sample.CpuDetail = &detail
+			mem, err := analysis.MemUsage()
+			if err != nil {
+				log.Println(err)
+			}
+			sample.Memory = mem
+			sample.Features = features.Sample()
+			sample.Disks = disks.Sample()
+			sample.Predicted, sample.PredictedInterval = s.model.PredictInterval(sampleFeatures(sample))
+			if machineMonitor.measured() {
+				r := machineMonitor.reading()
+				if r.err != nil && !powerFailed {
+					log.Println("Measure power read failed", s.ID, r.err)
+					powerFailed = true
+				}
+				if r.err == nil {
+					sample.Power = r.watts
+					sample.Measured = true
+				}
+				sample.PowerDetail = r.detail
+			}
+			if target != nil {
+				usage := target.Sample()
+				sample.Target = &usage
+			}
+			if n := s.record(sample); s.options.MaxSamples == 0 || n < s.options.MaxSamples {
+				continue
+			}
+			reason = stopMaxSamples
+		}
+		break
+	}
+
+	// Close the stop channel when the session ended on its own.
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.finish(reason)
+	s.persist()
+}
+
+// record stores a sample and hands it to every stream subscriber. A
+// subscriber that cannot keep up loses the sample instead of stalling the
+// sampler.
+func (s *Session) record(sample analysis.Sample) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = append(s.samples, sample)
+	for ch := range s.subscribers {
+		select {
+		case ch <- sample:
+		default:
+			log.Println("stream subscriber too slow, dropping sample", s.ID)
+		}
+	}
+	return len(s.samples)
+}
+
+// Subscribe returns the samples taken so far and a channel that receives
+// every following sample. The channel is closed when the session stops.
+func (s *Session) Subscribe() ([]analysis.Sample, chan analysis.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backlog := make([]analysis.Sample, len(s.samples))
+	copy(backlog, s.samples)
+	ch := make(chan analysis.Sample, 64)
+	if s.state == stateStopped {
+		close(ch)
+	} else {
+		s.subscribers[ch] = struct{}{}
+	}
+
+	return backlog, ch
+}
+
+// Unsubscribe detaches a stream subscriber that went away early.
+func (s *Session) Unsubscribe(ch chan analysis.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (s *Session) finish(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	measure := s.summarize()
+	log.Println("CPU Usage", measure.Cpu)
+	log.Println("MEM Usage", measure.Memory)
+	if watts, ok := meanPower(s.samples); ok {
+		log.Println("MEASURED POWER", watts)
+	}
+	log.Println("PREDICTED POWER", measure.Energy)
+	s.result = &measure
+	s.state = stateStopped
+	s.stopReason = reason
+	s.EndTime = time.Now()
+
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+	log.Println("Measure End", s.ID, reason, measure)
+}
+
+// persist writes the finished session to the measurement history and
+// drops it from the registry, so the history serves it from then on. A
+// session that could not be written stays in memory.
+func (s *Session) persist() {
+	if history == nil {
+		return
+	}
+
+	s.mu.Lock()
+	rec := store.Record{
+		ID:         s.ID,
+		Tags:       s.Tags,
+		StartTime:  s.StartTime,
+		EndTime:    s.EndTime,
+		Samples:    len(s.samples),
+		StopReason: s.stopReason,
+		Result:     *s.result,
+	}
+	samples := s.samples
+	s.mu.Unlock()
+
+	if err := history.Append(rec, samples); err != nil {
+		log.Println("Measure persist failed", s.ID, err)
+		return
+	}
+
+	sessions.mu.Lock()
+	delete(sessions.sessions, s.ID)
+	sessions.mu.Unlock()
+}
+
+// Stop ends sampling and blocks until the session result is available.
+func (s *Session) Stop() analysis.Analysis {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.result
+}
+
+// Wait blocks until the session has been stopped by someone else.
+func (s *Session) Wait() analysis.Analysis {
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.result
+}
+
+// State reports whether the session is running or stopped.
+func (s *Session) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Info returns a snapshot of the session. While the session is running the
+// result holds the summary of the samples taken so far.
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SessionInfo{
+		ID:         s.ID,
+		State:      s.state,
+		Tags:       s.Tags,
+		StartTime:  s.StartTime,
+		Options:    s.options,
+		Samples:    len(s.samples),
+		StopReason: s.stopReason,
+		Result:     s.result,
+	}
+	if s.state == stateStopped {
+		end := s.EndTime
+		info.EndTime = &end
+	} else if len(s.samples) > 0 {
+		partial := s.summarize()
+		info.Result = &partial
+	}
+
+	return info
+}
+
+// recordInfo turns a stored record into the same view as a live session.
+func recordInfo(rec store.Record) SessionInfo {
+	end := rec.EndTime
+	result := rec.Result
+	return SessionInfo{
+		ID:         rec.ID,
+		State:      stateStopped,
+		Tags:       rec.Tags,
+		StartTime:  rec.StartTime,
+		EndTime:    &end,
+		Samples:    rec.Samples,
+		StopReason: rec.StopReason,
+		Result:     &result,
+	}
+}
+
+// Samples returns a copy of the samples taken so far.
+func (s *Session) Samples() []analysis.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := make([]analysis.Sample, len(s.samples))
+	copy(samples, s.samples)
+	return samples
+}
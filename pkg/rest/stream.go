@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"analysis-model/pkg/store"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// writeEvent writes one Server-Sent Event and flushes it to the client.
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// StreamMeasurement pushes every sample of a session to the client as a
+// "sample" event while it is taken. Samples recorded before the client
+// connected are replayed first. A final "end" event carries the session
+// result once the session stops. A session that already ended is replayed
+// from the history.
+func StreamMeasurement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	s, live := getSession(id)
+	var rec store.Record
+	if !live {
+		var err error
+		if rec, err = history.Get(id); err != nil {
+			writeError(w, http.StatusNotFound, "measurement not found")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if !live {
+		replayRecord(w, flusher, rec)
+		return
+	}
+
+	backlog, ch := s.Subscribe()
+	defer s.Unsubscribe(ch)
+
+	for _, sample := range backlog {
+		if err := writeEvent(w, flusher, "sample", sample); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case sample, ok := <-ch:
+			if !ok {
+				if err := writeEvent(w, flusher, "end", s.Info()); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+			if err := writeEvent(w, flusher, "sample", sample); err != nil {
+				log.Println(err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// replayRecord streams the stored samples of a finished session followed by
+// its "end" event.
+func replayRecord(w http.ResponseWriter, flusher http.Flusher, rec store.Record) {
+	samples, err := history.Samples(rec.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, sample := range samples {
+		if err := writeEvent(w, flusher, "sample", sample); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := writeEvent(w, flusher, "end", recordInfo(rec)); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"log"
+	"time"
+
+	"analysis-model/pkg/analysis"
+	"analysis-model/pkg/power"
+)
+
+// meanPower averages the measured power of the samples whose read
+// succeeded. ok is false when none did.
+func meanPower(samples []analysis.Sample) (watts float64, ok bool) {
+	total := 0.0
+	count := 0
+	for _, sample := range samples {
+		if !sample.Measured {
+			continue
+		}
+		total = total + sample.Power
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return total / float64(count), true
+}
+
+// summarize reduces the samples of a session to its result. The caller
+// holds s.mu.
+func (s *Session) summarize() analysis.Analysis {
+	samples := s.samples
+	cpuTotal := 0.0
+	memTotal := 0.0
+	targetCpuTotal := 0.0
+	targetMemTotal := 0.0
+	targetCount := 0
+	featureTotals := make(map[string]float64)
+	var disks []analysis.DiskUsage
+	var cpuDetails []analysis.CPUDetail
+	for _, sample := range samples {
+		cpuTotal = cpuTotal + sample.Cpu
+		memTotal = memTotal + sample.Memory
+		disks = append(disks, sample.Disks...)
+		if sample.CpuDetail != nil {
+			cpuDetails = append(cpuDetails, *sample.CpuDetail)
+		}
+		for name, v := range sample.Features {
+			featureTotals[name] += v
+		}
+		if sample.Target != nil {
+			targetCpuTotal = targetCpuTotal + sample.Target.Cpu
+			targetMemTotal = targetMemTotal + sample.Target.Memory
+			targetCount++
+		}
+	}
+	average := analysis.Sample{}
+	if len(samples) > 0 {
+		average.Cpu = cpuTotal / float64(len(samples))
+		average.Memory = memTotal / float64(len(samples))
+		average.Features = make(map[string]float64, len(featureTotals))
+		for name, total := range featureTotals {
+			average.Features[name] = total / float64(len(samples))
+		}
+	}
+	cpuAvg := average.Cpu
+	memAvg := average.Memory
+
+	predict, interval := s.model.PredictInterval(sampleFeatures(average))
+
+	measure := analysis.Analysis{
+		Cpu:     cpuAvg,
+		Memory:  memAvg,
+		Energy:  predict,
+		Disks:   analysis.MergeDiskUsage(disks),
+		Samples: len(samples),
+		Model:   s.model.ID,
+		Profile: s.profile,
+
+		CpuDetail:      analysis.AverageCPUDetail(cpuDetails),
+		EnergyInterval: interval,
+	}
+	if targetCount > 0 {
+		targetCpu := targetCpuTotal / float64(targetCount)
+		targetMem := targetMemTotal / float64(targetCount)
+		measure.Target = &analysis.Analysis{
+			Cpu:    targetCpu,
+			Memory: targetMem,
+			Energy: s.targetPower(predict, average, targetCpu),
+		}
+	}
+	if len(samples) > 0 {
+		measure.Stats = s.sampleStats()
+		measure.Integrated = s.integrate()
+		if s.idleWatts > 0 {
+			breakdown := analysis.Breakdown(*measure.Integrated, s.idleWatts)
+			measure.Breakdown = &breakdown
+		}
+	}
+	if s.attribution != nil {
+		attributed := *s.attribution
+		measure.Attributed = &attributed
+	}
+
+	return measure
+}
+
+// targetPower is the target's share of the watts predicted for the
+// averaged system sample: its CPU share of the power above idle, split the
+// way analysis.Attribute splits measured energy. Idle is the profile's
+// baseline, or without one the prediction for the same sample with an
+// idle CPU.
+func (s *Session) targetPower(watts float64, average analysis.Sample, targetCpu float64) float64 {
+	idle := s.idleWatts
+	if idle <= 0 {
+		idleSample := average
+		idleSample.Cpu = 0
+		idle = predictPower(s.model, idleSample)
+	}
+	direct, _, _ := analysis.Attribute(watts, idle, average.Cpu, []float64{targetCpu}, analysis.RemainderNone)
+	return direct[0]
+}
+
+// integrate computes the session energy from measured power when the node
+// has it and from the predicted power otherwise. Samples whose power read
+// failed are left out and the readings around them bridge the gap; when
+// no read succeeded the energy is predicted. The first reading is held
+// back to the session start so the time before the first tick is counted.
+func (s *Session) integrate() *analysis.EnergyReport {
+	_, measured := meanPower(s.samples)
+	measured = measured && s.hasPower
+
+	times := make([]time.Time, 0, len(s.samples)+1)
+	watts := make([]float64, 0, len(s.samples)+1)
+	inputs := make([]map[string]float64, 0, len(s.samples)+1)
+	times = append(times, s.StartTime)
+	watts = append(watts, 0)
+	inputs = append(inputs, nil)
+	for _, sample := range s.samples {
+		if measured && !sample.Measured {
+			continue
+		}
+		times = append(times, sample.Time)
+		inputs = append(inputs, sampleFeatures(sample))
+		if measured {
+			watts = append(watts, sample.Power)
+		} else {
+			watts = append(watts, predictPower(s.model, sample))
+		}
+	}
+	watts[0] = watts[1]
+	inputs[0] = inputs[1]
+
+	report := analysis.Integrate(times, watts)
+	report.Source = analysis.SourceMeasured
+	if !measured {
+		report.Source = analysis.SourcePredicted
+		report.Interval = s.model.EnergyInterval(analysis.TrapezoidWeights(times), inputs)
+	}
+	return &report
+}
+
+// sampleStats computes the distribution of every series of a session. The
+// caller holds s.mu.
+func (s *Session) sampleStats() *analysis.Stats {
+	samples := s.samples
+	cpuList := make([]float64, 0, len(samples))
+	memList := make([]float64, 0, len(samples))
+	powerList := make([]float64, 0, len(samples))
+	predictList := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		cpuList = append(cpuList, sample.Cpu)
+		memList = append(memList, sample.Memory)
+		if sample.Measured {
+			powerList = append(powerList, sample.Power)
+		}
+		predictList = append(predictList, predictPower(s.model, sample))
+	}
+
+	stats := &analysis.Stats{
+		Cpu:            analysis.Summarize(cpuList),
+		Memory:         analysis.Summarize(memList),
+		PredictedPower: analysis.Summarize(predictList),
+	}
+	if s.hasPower && len(powerList) > 0 {
+		power := analysis.Summarize(powerList)
+		stats.Power = &power
+	}
+	return stats
+}
+
+// predictPower applies a power model to the CPU and memory usage and the
+// extra features of a sample.
+func predictPower(m power.PowerModel, sample analysis.Sample) float64 {
+	return m.Predict(sampleFeatures(sample))
+}
+
+// sampleFeatures returns every model feature of a sample by name.
+func sampleFeatures(sample analysis.Sample) map[string]float64 {
+	features := map[string]float64{
+		power.FeatureCPU:    sample.Cpu,
+		power.FeatureMemory: sample.Memory,
+	}
+	for name, v := range sample.Features {
+		features[name] = v
+	}
+	return features
+}
+
+// activeModel returns the model new sessions predict with: the current
+// profile's model when it names one, the store's active model otherwise.
+// Activating a model moves the profile along, see followModel.
+func activeModel() *power.Model {
+	if models == nil {
+		return power.DefaultModel()
+	}
+	if id := currentProfile().Model; id != "" {
+		m, err := models.Get(id)
+		if err == nil {
+			return m
+		}
+		log.Println("profile model", id, "unavailable:", err)
+	}
+	return models.Active()
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"analysis-model/pkg/analysis"
+)
+
+const (
+	indexFile  = "measurements.jsonl"
+	samplesDir = "samples"
+)
+
+// ErrNotFound is returned when no measurement has the requested ID.
+var ErrNotFound = errors.New("measurement not found")
+
+// Record is the summary of one finished measurement session.
+type Record struct {
+	ID         string            `json:"id"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	StartTime  time.Time         `json:"startTime"`
+	EndTime    time.Time         `json:"endTime"`
+	Samples    int               `json:"samples"`
+	StopReason string            `json:"stopReason,omitempty"`
+	Result     analysis.Analysis `json:"result"`
+}
+
+// Filter selects records by tags and start time. Zero values match all.
+type Filter struct {
+	Tags map[string]string
+	From time.Time
+	To   time.Time
+}
+
+// Store is an append-only on-disk measurement history. Summaries are kept
+// one per line in measurements.jsonl and the raw samples of every session in
+// samples/<id>.jsonl. The summaries are indexed in memory at Open.
+type Store struct {
+	dir string
+
+	mu      sync.RWMutex
+	records []Record
+	byID    map[string]int
+}
+
+// Open loads the history under dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, samplesDir), 0755); err != nil {
+		return nil, err
+	}
+	st := &Store{
+		dir:  dir,
+		byID: make(map[string]int),
+	}
+
+	f, err := os.Open(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A crash can leave a torn last line behind; skip it.
+			log.Println("store: skipping malformed record:", err)
+			continue
+		}
+		st.add(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	log.Println("store: loaded", len(st.records), "measurements from", dir)
+
+	return st, nil
+}
+
+func (st *Store) add(rec Record) {
+	if i, ok := st.byID[rec.ID]; ok {
+		st.records[i] = rec
+		return
+	}
+	st.byID[rec.ID] = len(st.records)
+	st.records = append(st.records, rec)
+}
+
+// Append persists a finished session together with its raw samples.
+func (st *Store) Append(rec Record, samples []analysis.Sample) error {
+	err := writeLines(filepath.Join(st.dir, samplesDir, rec.ID+".jsonl"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, func(enc *json.Encoder) error {
+		for _, sample := range samples {
+			if err := enc.Encode(sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = writeLines(filepath.Join(st.dir, indexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, func(enc *json.Encoder) error {
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	st.add(rec)
+	st.mu.Unlock()
+	return nil
+}
+
+// writeLines opens path with flag and writes JSON lines through encode,
+// syncing the file before it returns.
+func writeLines(path string, flag int, encode func(enc *json.Encoder) error) error {
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := encode(json.NewEncoder(w)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Get returns the summary of one measurement.
+func (st *Store) Get(id string) (Record, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	i, ok := st.byID[id]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return st.records[i], nil
+}
+
+// Samples reads back the raw samples of one measurement.
+func (st *Store) Samples(id string) ([]analysis.Sample, error) {
+	if _, err := st.Get(id); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(st.dir, samplesDir, id+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []analysis.Sample
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var sample analysis.Sample
+		if err := dec.Decode(&sample); err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// Len returns the number of stored measurements.
+func (st *Store) Len() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.records)
+}
+
+// List returns the summaries matching the filter, oldest first.
+func (st *Store) List(filter Filter) []Record {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	list := make([]Record, 0)
+	for _, rec := range st.records {
+		if filter.match(rec) {
+			list = append(list, rec)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime)
+	})
+	return list
+}
+
+func (f Filter) match(rec Record) bool {
+	if !f.From.IsZero() && rec.StartTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && rec.StartTime.After(f.To) {
+		return false
+	}
+	for key, value := range f.Tags {
+		got, ok := rec.Tags[key]
+		if !ok {
+			return false
+		}
+		if value != "" && !strings.EqualFold(got, value) {
+			return false
+		}
+	}
+	return true
+}
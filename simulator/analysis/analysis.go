@@ -16,6 +16,14 @@ type Analysis struct {
 	Cpu    float64 `json:"cpu"`
 	Memory float64 `json:"memory"`
 	Energy float64 `json:"energy"`
+
+	// EnergyInterval is the prediction interval of Energy when the power
+	// model has one.
+	EnergyInterval *Interval `json:"energyInterval,omitempty"`
+
+	// Integrated is the energy of the whole measurement in joules, next to
+	// the single wattage in Energy.
+	Integrated *EnergyReport `json:"integratedEnergy,omitempty"`
 }
 
 // var flag = 1
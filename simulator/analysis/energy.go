@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"time"
+)
+
+// Power sources an EnergyReport can be integrated from.
+const (
+	SourceMeasured  = "measured"
+	SourcePredicted = "predicted"
+)
+
+// EnergyReport is the energy used over a measurement, integrated from power
+// readings rather than averaged from them.
+type EnergyReport struct {
+	Joules   float64 `json:"joules"`
+	AvgWatts float64 `json:"avgWatts"`
+	Duration float64 `json:"duration"`
+	Source   string  `json:"source"`
+
+	// Interval bounds Joules when it was integrated from predicted power.
+	Interval *Interval `json:"interval,omitempty"`
+}
+
+// Interval is a prediction interval at the given level, such as 0.95.
+type Interval struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Level float64 `json:"level"`
+}
+
+// Integrate applies the trapezoidal rule to power readings in watts taken at
+// the given times. Duration is in seconds between the first and last
+// reading.
+func Integrate(times []time.Time, watts []float64) EnergyReport {
+	report := EnergyReport{}
+	if len(times) == 0 || len(times) != len(watts) {
+		return report
+	}
+	for i := 1; i < len(times); i++ {
+		dt := times[i].Sub(times[i-1]).Seconds()
+		report.Joules += dt * (watts[i] + watts[i-1]) / 2
+	}
+	report.Duration = times[len(times)-1].Sub(times[0]).Seconds()
+	if report.Duration > 0 {
+		report.AvgWatts = report.Joules / report.Duration
+	} else {
+		report.AvgWatts = watts[0]
+	}
+	return report
+}
+
+// TrapezoidWeights returns the weight of every reading in the trapezoidal
+// sum of Integrate, so that Joules is the weighted sum of the watts.
+func TrapezoidWeights(times []time.Time) []float64 {
+	weights := make([]float64, len(times))
+	for i := 1; i < len(times); i++ {
+		half := times[i].Sub(times[i-1]).Seconds() / 2
+		weights[i-1] += half
+		weights[i] += half
+	}
+	return weights
+}
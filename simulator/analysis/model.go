@@ -0,0 +1,191 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// Model is the part of a power model saved by the analysis server that the
+// simulator needs to predict power with a prediction interval. Load one
+// from the server's power/models directory with LoadModel.
+type Model struct {
+	ID            string             `json:"id"`
+	Type          string             `json:"type,omitempty"`
+	Intercept     float64            `json:"intercept"`
+	Coefficients  map[string]float64 `json:"coefficients"`
+	Normalization map[string]Range   `json:"normalization,omitempty"`
+	Uncertainty   *Uncertainty       `json:"uncertainty,omitempty"`
+}
+
+// Range is the min-max interval a column was normalised with.
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Uncertainty is the residual variance and inverse X'WX of the training
+// fit in the model's normalised space, with the features in row order
+// after the intercept.
+type Uncertainty struct {
+	Features         []string    `json:"features"`
+	ResidualVariance float64     `json:"residualVariance"`
+	DF               int         `json:"df"`
+	Covariance       [][]float64 `json:"covariance"`
+}
+
+// tTable holds the two-sided 95% critical values of Student's t
+// distribution for 1 to 30 degrees of freedom.
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func tCritical95(df int) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(tTable) {
+		return tTable[df-1]
+	}
+	return 1.96
+}
+
+// DefaultModel returns the built-in coefficients, which carry no
+// uncertainty.
+func DefaultModel() *Model {
+	return &Model{
+		ID:        "default",
+		Intercept: 96.2107,
+		Coefficients: map[string]float64{
+			"Cpu":    -0.4059,
+			"Memory": -17.2624,
+		},
+	}
+}
+
+// LoadModel reads a linear model JSON file written by the analysis server.
+// Models that use features beyond Cpu and Memory are rejected, since the
+// simulator would predict with them left at zero.
+func LoadModel(path string) (*Model, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Model{}
+	if err := json.Unmarshal(contents, m); err != nil {
+		return nil, err
+	}
+	if m.Type != "" && m.Type != "linear" {
+		return nil, fmt.Errorf("%s: the simulator only predicts with linear models, not %s", path, m.Type)
+	}
+	for _, name := range m.features() {
+		if name != "Cpu" && name != "Memory" {
+			return nil, fmt.Errorf("%s: the simulator only records Cpu and Memory, not %s", path, name)
+		}
+	}
+	return m, nil
+}
+
+// features returns the names of the features the model uses, sorted.
+func (m *Model) features() []string {
+	seen := make(map[string]bool)
+	for name := range m.Coefficients {
+		seen[name] = true
+	}
+	if m.Uncertainty != nil {
+		for _, name := range m.Uncertainty.Features {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Model) normalize(name string, v float64) float64 {
+	r, ok := m.Normalization[name]
+	if !ok || r.Max == r.Min {
+		return v
+	}
+	return (v - r.Min) / (r.Max - r.Min)
+}
+
+func (m *Model) powerScale() float64 {
+	r, ok := m.Normalization["Power"]
+	if !ok {
+		return 1
+	}
+	return r.Max - r.Min
+}
+
+// Predict returns the power in watts for CPU and memory usage.
+func (m *Model) Predict(cpu, mem float64) float64 {
+	features := map[string]float64{"Cpu": cpu, "Memory": mem}
+	watts := m.Intercept
+	for name, coeff := range m.Coefficients {
+		watts += coeff * m.normalize(name, features[name])
+	}
+	if r, ok := m.Normalization["Power"]; ok {
+		watts = r.Min + watts*(r.Max-r.Min)
+	}
+	return watts
+}
+
+func (m *Model) row(cpu, mem float64) []float64 {
+	features := map[string]float64{"Cpu": cpu, "Memory": mem}
+	x := []float64{1}
+	for _, name := range m.Uncertainty.Features {
+		x = append(x, m.normalize(name, features[name]))
+	}
+	return x
+}
+
+func (m *Model) quadratic(x []float64) float64 {
+	q := 0.0
+	for a, row := range m.Uncertainty.Covariance {
+		for b, c := range row {
+			q += x[a] * c * x[b]
+		}
+	}
+	return q
+}
+
+// PredictInterval returns the 95% prediction interval of one reading, or
+// nil when the model has no uncertainty.
+func (m *Model) PredictInterval(cpu, mem float64) *Interval {
+	if m.Uncertainty == nil {
+		return nil
+	}
+	watts := m.Predict(cpu, mem)
+	se := math.Sqrt(m.Uncertainty.ResidualVariance*(1+m.quadratic(m.row(cpu, mem)))) * m.powerScale()
+	half := tCritical95(m.Uncertainty.DF) * se
+	return &Interval{Low: watts - half, High: watts + half, Level: 0.95}
+}
+
+// EnergyInterval returns the 95% prediction interval of the energy summed
+// from readings with the given weights, or nil when the model has no
+// uncertainty.
+func (m *Model) EnergyInterval(weights, cpu, mem []float64) *Interval {
+	if m.Uncertainty == nil || len(weights) == 0 {
+		return nil
+	}
+	g := make([]float64, len(m.Uncertainty.Features)+1)
+	joules, noise := 0.0, 0.0
+	for i, w := range weights {
+		for j, v := range m.row(cpu[i], mem[i]) {
+			g[j] += w * v
+		}
+		noise += w * w
+		joules += w * m.Predict(cpu[i], mem[i])
+	}
+	se := math.Sqrt(m.Uncertainty.ResidualVariance*(m.quadratic(g)+noise)) * m.powerScale()
+	half := tCritical95(m.Uncertainty.DF) * se
+	return &Interval{Low: joules - half, High: joules + half, Level: 0.95}
+}
@@ -78,20 +78,27 @@ type ResponseA struct {
 	Data    interface{} `json:"data"`
 }
 type SSDInfo struct {
-	Query     string  `json:"query"`
-	CPU       float64 `json:"cpu"`
-	Energy    float64 `json:"energy"`
-	QueryTime float64 `json:"queryTime"`
+	Query     string             `json:"query"`
+	CPU       float64            `json:"cpu"`
+	Energy    float64            `json:"energy"`
+	Interval  *analysis.Interval `json:"interval,omitempty"`
+	QueryTime float64            `json:"queryTime"`
 }
 type CSDInfo struct {
-	CPU       float64 `json:"cpu"`
-	Energy    float64 `json:"energy"`
-	QueryTime float64 `json:"queryTime"`
+	CPU       float64            `json:"cpu"`
+	Energy    float64            `json:"energy"`
+	Interval  *analysis.Interval `json:"interval,omitempty"`
+	QueryTime float64            `json:"queryTime"`
 }
 
 var flag = 1
 var ans analysis.Analysis
 
+// powerModel predicts power from CPU and memory usage. Set POWER_MODEL to
+// a model file saved by the analysis server to use a trained model, which
+// also gives prediction intervals.
+var powerModel = analysis.DefaultModel()
+
 const rootDirectory = "/root/workspace/usr/coyg/module/tpch/"
 
 func resJsonParser(jsonDataString string) Response {
@@ -751,6 +758,35 @@ func Output(filterdata FilterData) ResponseA {
 	return tmp
 }
 
+// predictPower applies the linear power model to CPU and memory usage.
+func predictPower(cpu, mem float64) float64 {
+	return powerModel.Predict(cpu, mem)
+}
+
+// shift moves an interval by the same offset as the value it bounds.
+func shift(iv *analysis.Interval, offset float64) *analysis.Interval {
+	if iv == nil {
+		return nil
+	}
+	return &analysis.Interval{Low: iv.Low + offset, High: iv.High + offset, Level: iv.Level}
+}
+
+// savings formats csd/ssd energy in percent with its prediction interval.
+// Both energies are offsets of the same prediction, so their errors are
+// one error: the ratio is bounded at the low and at the high end of the
+// shared interval together, not by the worst pairing of the two.
+func savings(csd CSDInfo, ssd SSDInfo) string {
+	ratio := fmt.Sprintf("%v %%", csd.Energy/ssd.Energy*100)
+	if csd.Interval == nil || ssd.Interval == nil {
+		return ratio
+	}
+	low, high := csd.Interval.Low/ssd.Interval.Low*100, csd.Interval.High/ssd.Interval.High*100
+	if low > high {
+		low, high = high, low
+	}
+	return fmt.Sprintf("%s (95%% PI %0.1f-%0.1f %%)", ratio, low, high)
+}
+
 // start measure
 func StartMeasure(mc chan analysis.Analysis) {
 	log.Println("Measure Start")
@@ -766,14 +802,21 @@ func StartMeasure(mc chan analysis.Analysis) {
 	// go analysis.GetMem()
 	// log.Println(ans)
 
+	startTime := time.Now()
+	var timeList []time.Time
+	var powerList []float64
+
 	for {
 		if flag == 0 {
 			break
 		}
 		go analysis.GetCPU(cpuChan)
 		go analysis.GetMem(memChan)
-		cpuList = append(cpuList, <-cpuChan)
-		memList = append(memList, <-memChan)
+		cpu, mem := <-cpuChan, <-memChan
+		cpuList = append(cpuList, cpu)
+		memList = append(memList, mem)
+		timeList = append(timeList, time.Now())
+		powerList = append(powerList, predictPower(cpu, mem))
 	}
 	cpuTotal := 0.0
 	for _, cpu := range cpuList {
@@ -789,13 +832,26 @@ func StartMeasure(mc chan analysis.Analysis) {
 	// log.Println("CPU Usage", cpuAvg)
 	// log.Println("MEM Usage", memAvg)
 
-	predict := 96.2107 + (cpuAvg * -(0.4059)) + (memAvg * (-17.2624))
+	predict := predictPower(cpuAvg, memAvg)
 	// log.Println("POWER Usage", predict)
 
 	measure := analysis.Analysis{
-		Cpu:    cpuAvg,
-		Memory: memAvg,
-		Energy: predict,
+		Cpu:            cpuAvg,
+		Memory:         memAvg,
+		Energy:         predict,
+		EnergyInterval: powerModel.PredictInterval(cpuAvg, memAvg),
+	}
+	if len(powerList) > 0 {
+		// Hold the first reading back to the start so the first second counts.
+		times := append([]time.Time{startTime}, timeList...)
+		energy := analysis.Integrate(times, append([]float64{powerList[0]}, powerList...))
+		energy.Source = analysis.SourcePredicted
+		energy.Interval = powerModel.EnergyInterval(
+			analysis.TrapezoidWeights(times),
+			append([]float64{cpuList[0]}, cpuList...),
+			append([]float64{memList[0]}, memList...),
+		)
+		measure.Integrated = &energy
 	}
 	// log.Println(measure)
 	ans = measure
@@ -807,6 +863,15 @@ func StartMeasure(mc chan analysis.Analysis) {
 func main() {
 	log.SetFlags(log.Lshortfile)
 
+	if path := os.Getenv("POWER_MODEL"); path != "" {
+		m, err := analysis.LoadModel(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		powerModel = m
+		log.Println("Power Model >", m.ID)
+	}
+
 	measureChan := make(chan analysis.Analysis)
 	var qList []string
 
@@ -826,6 +891,15 @@ func main() {
 		ans := <-measureChan
 		fmt.Println("CPU resource savings: ", ans.Cpu, "%")
 		fmt.Println("Energy resource savings: ", ans.Energy, "%")
+		if iv := ans.EnergyInterval; iv != nil {
+			fmt.Printf("Power: %0.1f W (95%% PI %0.1f-%0.1f W)\n", ans.Energy, iv.Low, iv.High)
+		}
+		if ans.Integrated != nil {
+			fmt.Printf("Energy: %0.1f J over %0.1f sec (%0.1f W avg)\n", ans.Integrated.Joules, ans.Integrated.Duration, ans.Integrated.AvgWatts)
+			if iv := ans.Integrated.Interval; iv != nil {
+				fmt.Printf("Energy 95%% PI: %0.1f-%0.1f J\n", iv.Low, iv.High)
+			}
+		}
 		fmt.Println("Query Performance: ", endTime, "%")
 		// log.Println(ans.Cpu)
 		// log.Println(ans.Memory)
@@ -834,8 +908,8 @@ func main() {
 		flag = 1
 		// cpur := rand.floa
 		// ~150, ~10, ~150
-		ssdList = append(ssdList, SSDInfo{CPU: ans.Cpu + 60, QueryTime: endTime + 1.35, Energy: ans.Energy + 40, Query: query})
-		csdList = append(csdList, CSDInfo{CPU: ans.Cpu, QueryTime: endTime, Energy: ans.Energy + 48})
+		ssdList = append(ssdList, SSDInfo{CPU: ans.Cpu + 60, QueryTime: endTime + 1.35, Energy: ans.Energy + 40, Interval: shift(ans.EnergyInterval, 40), Query: query})
+		csdList = append(csdList, CSDInfo{CPU: ans.Cpu, QueryTime: endTime, Energy: ans.Energy + 48, Interval: shift(ans.EnergyInterval, 48)})
 	}
 	fmt.Println(ssdList)
 	fmt.Println(csdList)
@@ -850,7 +924,7 @@ func main() {
 		// fmt.Println("Pushdown	", "Index Pushdown")
 		fmt.Println("Query Performance: ", ssdList[i].QueryTime/csdList[i].QueryTime*100, "%")
 		fmt.Println("CPU resource savings: ", csdList[i].CPU/ssdList[i].CPU*100, "%")
-		fmt.Println("Energy resource savings: ", csdList[i].Energy/ssdList[i].Energy*100, "%")
+		fmt.Println("Energy resource savings: ", savings(csdList[i], ssdList[i]))
 		fmt.Println("----------------------------------------------")
 	}
 
@@ -876,7 +950,7 @@ func main() {
 	fmt.Println("Energy resource savings")
 	for i, dd := range ssdList {
 		fmt.Println("Query:	", dd.Query)
-		fmt.Println("Energy resource savings: ", csdList[i].Energy/ssdList[i].Energy*100, "%")
+		fmt.Println("Energy resource savings: ", savings(csdList[i], ssdList[i]))
 		fmt.Println("----------------------------------------------")
 	}
 	fmt.Println()